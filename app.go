@@ -0,0 +1,150 @@
+package leaf
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/somethinghero/leaf/cluster"
+	"github.com/somethinghero/leaf/console"
+	"github.com/somethinghero/leaf/module"
+)
+
+//App desc:
+// a leaf instance with its own modules, several apps can run in one process
+// the console and the cluster are process wide, Start of an app returns an
+// error if it enables one of them while another app runs it
+type App struct {
+	DisableConsole bool
+	DisableCluster bool
+	mods           *module.Manager
+	mutex          sync.Mutex
+	started        bool
+	stopped        bool
+	// the parts started by the app, the others are not destroyed by it
+	consoleStarted    bool
+	commandRegistered bool
+	clusterStarted    bool
+	chanStopped    chan struct{}
+	stopErr        error
+}
+
+//NewApp NewApp
+func NewApp(mods ...module.Module) *App {
+	app := new(App)
+	app.mods = module.NewManager()
+	app.chanStopped = make(chan struct{})
+	for _, mi := range mods {
+		app.mods.Register(mi)
+	}
+	return app
+}
+
+//Register you must call the function before calling Start
+func (app *App) Register(mi module.Module) {
+	app.mods.Register(mi)
+}
+
+//Start desc:
+// startup:  modules -> cluster -> console
+// on failure the started parts are destroyed and the error is returned
+func (app *App) Start(ctx context.Context) error {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	if app.started {
+		return errors.New("app is already started")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	app.started = true
+
+	// module
+	err := app.mods.Init()
+	if err != nil {
+		app.destroy(ctx)
+		return err
+	}
+
+	// cluster
+	if !app.DisableCluster && cluster.Enabled() {
+		err = cluster.Init()
+		if err != nil {
+			app.destroy(ctx)
+			return err
+		}
+		app.clusterStarted = true
+	}
+
+	// console
+	if !app.DisableConsole && console.Enabled() {
+		err = console.Init()
+		if err != nil {
+			app.destroy(ctx)
+			return err
+		}
+		app.consoleStarted = true
+		err = console.RegisterFunc("modules", "state of all modules", app.commandModules)
+		if err != nil {
+			app.destroy(ctx)
+			return err
+		}
+		app.commandRegistered = true
+	}
+
+	return nil
+}
+
+//Stop desc:
+// shutdown: console -> cluster -> modules
+// the modules share the time left before the deadline of ctx
+// goroutine safe, only the first call stops the app
+func (app *App) Stop(ctx context.Context) error {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	if !app.started {
+		return errors.New("app is not started")
+	}
+	if app.stopped {
+		return app.stopErr
+	}
+
+	return app.destroy(ctx)
+}
+
+func (app *App) destroy(ctx context.Context) error {
+	if app.consoleStarted {
+		console.Destroy()
+	}
+	if app.commandRegistered {
+		console.Unregister("modules")
+	}
+	if app.clusterStarted {
+		cluster.Destroy()
+	}
+	app.stopErr = app.mods.DestroyContext(ctx)
+
+	app.stopped = true
+	close(app.chanStopped)
+	return app.stopErr
+}
+
+//Modules the modules of the app, to query their states
+func (app *App) Modules() *module.Manager {
+	return app.mods
+}
+
+func (app *App) commandModules([]string) string {
+	var output []string
+	for _, name := range app.mods.Names() {
+		state, _ := app.mods.State(name)
+		output = append(output, name+" - "+state.String())
+	}
+	return strings.Join(output, "\r\n")
+}
+
+//Wait block until the app is stopped
+func (app *App) Wait() {
+	<-app.chanStopped
+}
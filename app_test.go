@@ -0,0 +1,92 @@
+package leaf_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf"
+	"github.com/somethinghero/leaf/conf"
+)
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func startApp(t *testing.T, name string) *leaf.App {
+	app := leaf.NewApp(&echo{name: name})
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Stop(context.Background()) })
+	return app
+}
+
+// the console and the cluster belong to the first app enabling them
+func TestAppProcessWide(t *testing.T) {
+	defer func(port int, prompt, listenAddr, nodeName string) {
+		conf.ConsolePort, conf.ConsolePrompt = port, prompt
+		conf.ListenAddr, conf.NodeName = listenAddr, nodeName
+	}(conf.ConsolePort, conf.ConsolePrompt, conf.ListenAddr, conf.NodeName)
+
+	// disabled, both apps start
+	conf.ConsolePort = 0
+	conf.ListenAddr = ""
+	startApp(t, "a")
+	startApp(t, "b")
+
+	conf.ConsolePort = freePort(t)
+	conf.ConsolePrompt = ""
+	conf.ListenAddr = "localhost:" + strconv.Itoa(freePort(t))
+	conf.NodeName = "node"
+	app1 := startApp(t, "m1")
+
+	for _, disable := range []struct{ console, cluster bool }{
+		{false, true},
+		{true, false},
+	} {
+		app2 := leaf.NewApp(&echo{name: "m2"})
+		app2.DisableConsole = disable.console
+		app2.DisableCluster = disable.cluster
+		if err := app2.Start(context.Background()); err == nil {
+			app2.Stop(context.Background())
+			t.Fatalf("%+v: started", disable)
+		}
+	}
+
+	// still served for app1
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(conf.ConsolePort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("modules\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "m1 - running") {
+		t.Fatalf("%q", line)
+	}
+	clusterConn, err := net.Dial("tcp", conf.ListenAddr)
+	if err != nil {
+		t.Fatalf("the cluster is destroyed: %v", err)
+	}
+	clusterConn.Close()
+
+	// released by app1
+	if err := app1.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	startApp(t, "m3")
+}
@@ -1,6 +1,7 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
@@ -18,6 +19,7 @@ type Server struct {
 	// function:
 	// func(args []interface{})
 	// func(args []interface{}) interface{}
+	// func(args []interface{}) (interface{}, error)
 	// func(args []interface{}) []interface{}
 	functions map[interface{}]interface{}
 	ChanCall  chan *CallInfo
@@ -73,6 +75,7 @@ func (s *Server) Register(id interface{}, f interface{}) {
 	switch f.(type) {
 	case func([]interface{}):
 	case func([]interface{}) interface{}:
+	case func([]interface{}) (interface{}, error):
 	case func([]interface{}) []interface{}:
 	default:
 		panic(fmt.Sprintf("function id %v: definition of function is invalid", id))
@@ -124,6 +127,9 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 	case func([]interface{}) interface{}:
 		ret := ci.f.(func([]interface{}) interface{})(ci.args)
 		return s.ret(ci, &RetInfo{ret: ret})
+	case func([]interface{}) (interface{}, error):
+		ret, err := ci.f.(func([]interface{}) (interface{}, error))(ci.args)
+		return s.ret(ci, &RetInfo{ret: ret, err: err})
 	case func([]interface{}) []interface{}:
 		ret := ci.f.(func([]interface{}) []interface{})(ci.args)
 		return s.ret(ci, &RetInfo{ret: ret})
@@ -172,6 +178,21 @@ func (s *Server) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return s.Open(0).CallN(id, args...)
 }
 
+//Call0Context goroutine safe
+func (s *Server) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	return s.Open(0).Call0Context(ctx, id, args...)
+}
+
+//Call1Context goroutine safe
+func (s *Server) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	return s.Open(0).Call1Context(ctx, id, args...)
+}
+
+//CallNContext goroutine safe
+func (s *Server) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	return s.Open(0).CallNContext(ctx, id, args...)
+}
+
 //Close Close
 func (s *Server) Close() {
 	close(s.ChanCall)
@@ -222,6 +243,26 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 	return
 }
 
+func callContext(ctx context.Context, s *Server, ci *CallInfo) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	// select picks randomly if both are ready
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	select {
+	case s.ChanCall <- ci:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
 func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	if c.s == nil {
 		err = errors.New("server not attached")
@@ -239,7 +280,12 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	case 0:
 		_, ok = f.(func([]interface{}))
 	case 1:
-		_, ok = f.(func([]interface{}) interface{})
+		switch f.(type) {
+		case func([]interface{}) interface{}:
+			ok = true
+		case func([]interface{}) (interface{}, error):
+			ok = true
+		}
 	case 2:
 		_, ok = f.(func([]interface{}) []interface{})
 	default:
@@ -312,6 +358,59 @@ func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return assert(ri.ret), ri.err
 }
 
+func (c *Client) callWithContext(ctx context.Context, id interface{}, args []interface{}, n int) (*RetInfo, error) {
+	f, err := c.f(id, n)
+	if err != nil {
+		return nil, err
+	}
+
+	// a late result must not be taken as the result of the next call,
+	// so every call gets its own channel instead of chanSyncRet
+	chanRet := make(chan *RetInfo, 1)
+	err = callContext(ctx, c.s, &CallInfo{
+		f:       f,
+		args:    args,
+		chanRet: chanRet,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ri := <-chanRet:
+		return ri, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//Call0Context like Call0, return ctx.Err() if ctx is done before the call returns
+func (c *Client) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.callWithContext(ctx, id, args, 0)
+	if err != nil {
+		return err
+	}
+	return ri.err
+}
+
+//Call1Context like Call1, return ctx.Err() if ctx is done before the call returns
+func (c *Client) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	ri, err := c.callWithContext(ctx, id, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return ri.ret, ri.err
+}
+
+//CallNContext like CallN, return ctx.Err() if ctx is done before the call returns
+func (c *Client) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.callWithContext(ctx, id, args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return assert(ri.ret), ri.err
+}
+
 func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n int) {
 	f, err := c.f(id, n)
 	if err != nil {
@@ -331,16 +430,47 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 	}
 }
 
-//AsynCall AsynCall
-func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
+func (c *Client) asynCallContext(ctx context.Context, id interface{}, args []interface{}, cb interface{}, n int) {
+	f, err := c.f(id, n)
+	if err != nil {
+		c.ChanAsynRet <- &RetInfo{err: err, cb: cb}
+		return
+	}
+
+	// the server replies to chanRet, exactly one RetInfo is forwarded
+	// to ChanAsynRet and a late result is dropped with chanRet
+	s := c.s
+	chanRet := make(chan *RetInfo, 1)
+	ci := &CallInfo{
+		f:       f,
+		args:    args,
+		chanRet: chanRet,
+		cb:      cb,
+	}
+	go func() {
+		err := callContext(ctx, s, ci)
+		if err != nil {
+			c.ChanAsynRet <- &RetInfo{err: err, cb: cb}
+			return
+		}
+
+		select {
+		case ri := <-chanRet:
+			c.ChanAsynRet <- ri
+		case <-ctx.Done():
+			c.ChanAsynRet <- &RetInfo{err: ctx.Err(), cb: cb}
+		}
+	}()
+}
+
+func parseAsynArgs(_args []interface{}) (args []interface{}, cb interface{}, n int) {
 	if len(_args) < 1 {
 		panic("callback function not found")
 	}
 
-	args := _args[:len(_args)-1]
-	cb := _args[len(_args)-1]
+	args = _args[:len(_args)-1]
+	cb = _args[len(_args)-1]
 
-	var n int
 	switch cb.(type) {
 	case func(error):
 		n = 0
@@ -351,6 +481,12 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 	default:
 		panic("definition of callback function is invalid")
 	}
+	return
+}
+
+//AsynCall AsynCall
+func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
+	args, cb, n := parseAsynArgs(_args)
 
 	// too many calls
 	if c.pendingAsynCall >= cap(c.ChanAsynRet) {
@@ -362,6 +498,22 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 	c.pendingAsynCall++
 }
 
+//AsynCallContext desc:
+// like AsynCall, but the callback is called exactly once with ctx.Err()
+// if ctx is done before the call returns, the late result is dropped
+func (c *Client) AsynCallContext(ctx context.Context, id interface{}, _args ...interface{}) {
+	args, cb, n := parseAsynArgs(_args)
+
+	// too many calls
+	if c.pendingAsynCall >= cap(c.ChanAsynRet) {
+		execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
+		return
+	}
+
+	c.asynCallContext(ctx, id, args, cb, n)
+	c.pendingAsynCall++
+}
+
 func execCb(ri *RetInfo) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -1,9 +1,12 @@
 package chanrpc_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/somethinghero/leaf/chanrpc"
 	"sync"
+	"time"
 )
 
 func Example() {
@@ -125,3 +128,81 @@ func Example() {
 	// 1 2 3
 	// 3
 }
+
+func ExampleClient_AsynCallContext() {
+	s := chanrpc.NewServer(10)
+	s.Register("stuck", func(args []interface{}) {})
+
+	// nobody executes the calls of s
+	c := s.Open(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// sync
+	err := c.Call0Context(ctx, "stuck")
+	fmt.Println(err)
+
+	// asyn
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.AsynCallContext(ctx, "stuck", func(err error) {
+		fmt.Println(err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+
+	// the late result is dropped
+	s.Exec(<-s.ChanCall)
+	s.Exec(<-s.ChanCall)
+	fmt.Println(c.Idle())
+
+	// Output:
+	// context deadline exceeded
+	// context deadline exceeded
+	// true
+}
+
+type addReq struct {
+	N1, N2 int
+}
+
+func ExampleFunc() {
+	add := chanrpc.NewFunc[*addReq, int]("add")
+
+	s := chanrpc.NewServer(10)
+	add.Register(s, func(ctx context.Context, req *addReq) (int, error) {
+		if req.N1 < 0 || req.N2 < 0 {
+			return 0, errors.New("negative number")
+		}
+		return req.N1 + req.N2, nil
+	})
+	go func() {
+		for {
+			s.Exec(<-s.ChanCall)
+		}
+	}()
+
+	c := s.Open(10)
+	ctx := context.Background()
+
+	// sync
+	fmt.Println(add.Call(ctx, c, &addReq{1, 2}))
+	fmt.Println(add.Call(ctx, c, &addReq{-1, 2}))
+
+	// asyn
+	add.AsynCall(ctx, c, &addReq{3, 4}, func(ret int, err error) {
+		fmt.Println(ret, err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+
+	// the interface{} API still works
+	fmt.Println(c.Call1("add", &addReq{5, 6}))
+	fmt.Println(c.Call1("add", "7"))
+
+	// Output:
+	// 3 <nil>
+	// 0 negative number
+	// 7 <nil>
+	// 11 <nil>
+	// <nil> function id add: argument type string mismatch
+}
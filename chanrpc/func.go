@@ -0,0 +1,91 @@
+package chanrpc
+
+import (
+	"context"
+	"fmt"
+)
+
+//Func desc:
+// a function id bound to its request and response types, registration
+// and calls through it are checked at compile time
+//
+// the handler is registered as func(args []interface{}) (interface{}, error)
+// and can also be called with the interface{} API: Call1(id, ctx, req)
+type Func[Req, Resp any] struct {
+	id interface{}
+}
+
+//NewFunc NewFunc
+func NewFunc[Req, Resp any](id interface{}) *Func[Req, Resp] {
+	return &Func[Req, Resp]{id: id}
+}
+
+//ID ID
+func (f *Func[Req, Resp]) ID() interface{} {
+	return f.id
+}
+
+//Register you must call the function before calling Open and Go
+func (f *Func[Req, Resp]) Register(s *Server, h func(context.Context, Req) (Resp, error)) {
+	s.Register(f.id, func(args []interface{}) (interface{}, error) {
+		ctx, req, err := f.args(args)
+		if err != nil {
+			return nil, err
+		}
+		return h(ctx, req)
+	})
+}
+
+// args: [ctx,] req
+func (f *Func[Req, Resp]) args(args []interface{}) (context.Context, Req, error) {
+	var req Req
+
+	ctx := context.Background()
+	if len(args) > 0 {
+		if c, ok := args[0].(context.Context); ok {
+			ctx = c
+			args = args[1:]
+		}
+	}
+
+	if len(args) != 1 {
+		return ctx, req, fmt.Errorf("function id %v: %v arguments, want 1", f.id, len(args))
+	}
+	if args[0] != nil {
+		r, ok := args[0].(Req)
+		if !ok {
+			return ctx, req, fmt.Errorf("function id %v: argument type %T mismatch", f.id, args[0])
+		}
+		req = r
+	}
+	return ctx, req, nil
+}
+
+func (f *Func[Req, Resp]) resp(ret interface{}, err error) (Resp, error) {
+	var resp Resp
+	if ret != nil {
+		r, ok := ret.(Resp)
+		if !ok {
+			return resp, fmt.Errorf("function id %v: return type %T mismatch", f.id, ret)
+		}
+		resp = r
+	}
+	return resp, err
+}
+
+//Call sync call through c, see Client.Call1Context
+func (f *Func[Req, Resp]) Call(ctx context.Context, c *Client, req Req) (Resp, error) {
+	return f.resp(c.Call1Context(ctx, f.id, ctx, req))
+}
+
+//AsynCall the callback is called by c.Cb, see Client.AsynCallContext
+func (f *Func[Req, Resp]) AsynCall(ctx context.Context, c *Client, req Req, cb func(Resp, error)) {
+	c.AsynCallContext(ctx, f.id, ctx, req, func(ret interface{}, err error) {
+		cb(f.resp(ret, err))
+	})
+}
+
+//Go goroutine safe
+func (f *Func[Req, Resp]) Go(s *Server, req Req) {
+	s.Go(f.id, req)
+}
@@ -0,0 +1,349 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/somethinghero/leaf/conf"
+	"github.com/somethinghero/leaf/log"
+	"github.com/somethinghero/leaf/network"
+)
+
+//Agent connection to another node
+type Agent struct {
+	sync.Mutex
+	conn        *network.TCPConn
+	name        string
+	seq         uint32
+	pendingCall map[uint32]chan *message
+	closeFlag   bool
+
+	// the client dialed the connection, nil if it is accepted
+	client *network.TCPClient
+	// another connection to the same node is kept, see handshake
+	redundant bool
+}
+
+func newAgent(conn *network.TCPConn, client *network.TCPClient) *Agent {
+	a := new(Agent)
+	a.conn = conn
+	a.client = client
+	a.pendingCall = make(map[uint32]chan *message)
+	return a
+}
+
+//Name name of the remote node, empty before handshake
+func (a *Agent) Name() string {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.name
+}
+
+//Run Run
+func (a *Agent) Run() {
+	err := a.write(&message{Type: msgHandshake, Name: conf.NodeName})
+	if err != nil {
+		log.Error("handshake with %v error: %v", a.conn.RemoteAddr(), err)
+		return
+	}
+
+	handshake := false
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message: %v", err)
+			break
+		}
+
+		msg, err := decode(data)
+		if err != nil {
+			log.Error("decode message error: %v", err)
+			break
+		}
+
+		if !handshake {
+			if msg.Type != msgHandshake {
+				log.Error("handshake with %v error: unexpected message type %v", a.conn.RemoteAddr(), msg.Type)
+				break
+			}
+			if !a.handshake(msg.Name) {
+				break
+			}
+			handshake = true
+			continue
+		}
+
+		switch msg.Type {
+		case msgCall:
+			a.handleCall(msg)
+		case msgRet:
+			a.handleRet(msg)
+		default:
+			log.Error("node %v: unexpected message type %v", a.name, msg.Type)
+		}
+	}
+
+	// the other node dials this one, do not dial it again
+	a.Lock()
+	redundant := a.redundant
+	a.Unlock()
+	if redundant && a.client != nil {
+		a.client.AutoReconnect = false
+	}
+}
+
+// the node which dialed the connection
+func (a *Agent) dialer(name string) string {
+	if a.client != nil {
+		return conf.NodeName
+	}
+	return name
+}
+
+// two nodes dial each other, the connection dialed by the node with the
+// smaller name is kept
+func (a *Agent) preferred(name string) bool {
+	if conf.NodeName < name {
+		return a.dialer(name) == conf.NodeName
+	}
+	return a.dialer(name) == name
+}
+
+func (a *Agent) handshake(name string) bool {
+	if name == "" {
+		log.Error("handshake with %v error: empty node name", a.conn.RemoteAddr())
+		return false
+	}
+	if name == conf.NodeName {
+		log.Error("handshake with %v error: node %v connected to itself", a.conn.RemoteAddr(), name)
+		return false
+	}
+
+	mutexAgents.Lock()
+	defer mutexAgents.Unlock()
+	if old, ok := agents[name]; ok {
+		if old.dialer(name) == a.dialer(name) {
+			log.Error("handshake with %v error: node %v is already connected", a.conn.RemoteAddr(), name)
+			return false
+		}
+
+		// dialed by each other
+		if !a.preferred(name) {
+			log.Release("node %v is connected by %v, close %v", name, old.dialer(name), a.conn.RemoteAddr())
+			a.Lock()
+			a.redundant = true
+			a.Unlock()
+			return false
+		}
+		log.Release("node %v is connected by %v, close %v", name, a.dialer(name), old.conn.RemoteAddr())
+		old.Lock()
+		old.redundant = true
+		old.Unlock()
+		old.conn.Close()
+	}
+	agents[name] = a
+
+	a.Lock()
+	a.name = name
+	a.Unlock()
+
+	log.Release("node %v connected (%v)", name, a.conn.RemoteAddr())
+	return true
+}
+
+//OnClose OnClose
+func (a *Agent) OnClose() {
+	a.Lock()
+	name := a.name
+	a.closeFlag = true
+	pendingCall := a.pendingCall
+	a.pendingCall = nil
+	a.Unlock()
+
+	if name != "" {
+		mutexAgents.Lock()
+		current := agents[name] == a
+		if current {
+			delete(agents, name)
+		}
+		mutexAgents.Unlock()
+
+		if current {
+			log.Release("node %v disconnected", name)
+		}
+	}
+
+	for _, chanRet := range pendingCall {
+		chanRet <- &message{Type: msgRet, Err: "cluster node closed"}
+	}
+}
+
+func (a *Agent) write(msg *message) error {
+	data, err := encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return a.conn.WriteMsg(data)
+}
+
+func (a *Agent) handleCall(msg *message) {
+	s := servers[msg.Server]
+	if s == nil {
+		err := fmt.Errorf("server %v not registered", msg.Server)
+		if msg.N == callGo {
+			log.Error("node %v: %v", a.name, err)
+			return
+		}
+		a.ret(&message{Type: msgRet, Seq: msg.Seq, Err: err.Error()})
+		return
+	}
+
+	if msg.N == callGo {
+		s.Go(msg.ID, msg.Args...)
+		return
+	}
+
+	// do not block the read loop
+	go func() {
+		ret := &message{Type: msgRet, Seq: msg.Seq}
+
+		var err error
+		switch msg.N {
+		case call0:
+			err = s.Call0(msg.ID, msg.Args...)
+		case call1:
+			ret.Ret, err = s.Call1(msg.ID, msg.Args...)
+		case callN:
+			ret.Ret, err = s.CallN(msg.ID, msg.Args...)
+		default:
+			err = fmt.Errorf("invalid return type %v", msg.N)
+		}
+		if err != nil {
+			ret.Err = err.Error()
+		}
+
+		a.ret(ret)
+	}()
+}
+
+func (a *Agent) ret(ret *message) {
+	err := a.write(ret)
+	if err == nil {
+		return
+	}
+
+	// the result can not be encoded, report it to the caller
+	ret.Ret = nil
+	ret.Err = err.Error()
+	err = a.write(ret)
+	if err != nil {
+		log.Error("node %v: write ret error: %v", a.name, err)
+	}
+}
+
+func (a *Agent) handleRet(msg *message) {
+	a.Lock()
+	chanRet := a.pendingCall[msg.Seq]
+	delete(a.pendingCall, msg.Seq)
+	a.Unlock()
+
+	if chanRet != nil {
+		chanRet <- msg
+	}
+}
+
+func (a *Agent) call(ctx context.Context, server string, id interface{}, args []interface{}, n int) (interface{}, error) {
+	chanRet := make(chan *message, 1)
+
+	a.Lock()
+	if a.closeFlag {
+		a.Unlock()
+		return nil, errors.New("cluster node closed")
+	}
+	a.seq++
+	seq := a.seq
+	a.pendingCall[seq] = chanRet
+	a.Unlock()
+
+	err := a.write(&message{
+		Type:   msgCall,
+		Seq:    seq,
+		Server: server,
+		ID:     id,
+		Args:   args,
+		N:      n,
+	})
+	if err != nil {
+		a.Lock()
+		delete(a.pendingCall, seq)
+		a.Unlock()
+		return nil, err
+	}
+
+	select {
+	case ret := <-chanRet:
+		if ret.Err != "" {
+			return nil, errors.New(ret.Err)
+		}
+		return ret.Ret, nil
+	case <-ctx.Done():
+		a.Lock()
+		delete(a.pendingCall, seq)
+		a.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+//Go goroutine safe
+func (a *Agent) Go(server string, id interface{}, args ...interface{}) {
+	err := a.write(&message{
+		Type:   msgCall,
+		Server: server,
+		ID:     id,
+		Args:   args,
+		N:      callGo,
+	})
+	if err != nil {
+		log.Error("node %v: go %v error: %v", a.Name(), id, err)
+	}
+}
+
+//Call0 goroutine safe
+func (a *Agent) Call0(server string, id interface{}, args ...interface{}) error {
+	return a.Call0Context(context.Background(), server, id, args...)
+}
+
+//Call1 goroutine safe
+func (a *Agent) Call1(server string, id interface{}, args ...interface{}) (interface{}, error) {
+	return a.Call1Context(context.Background(), server, id, args...)
+}
+
+//CallN goroutine safe
+func (a *Agent) CallN(server string, id interface{}, args ...interface{}) ([]interface{}, error) {
+	return a.CallNContext(context.Background(), server, id, args...)
+}
+
+//Call0Context goroutine safe, return ctx.Err() if ctx is done before the call returns
+func (a *Agent) Call0Context(ctx context.Context, server string, id interface{}, args ...interface{}) error {
+	_, err := a.call(ctx, server, id, args, call0)
+	return err
+}
+
+//Call1Context goroutine safe, return ctx.Err() if ctx is done before the call returns
+func (a *Agent) Call1Context(ctx context.Context, server string, id interface{}, args ...interface{}) (interface{}, error) {
+	return a.call(ctx, server, id, args, call1)
+}
+
+//CallNContext goroutine safe, return ctx.Err() if ctx is done before the call returns
+func (a *Agent) CallNContext(ctx context.Context, server string, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ret, err := a.call(ctx, server, id, args, callN)
+	if err != nil {
+		return nil, err
+	}
+	rets, _ := ret.([]interface{})
+	return rets, nil
+}
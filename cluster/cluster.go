@@ -1,71 +1,241 @@
 package cluster
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math"
+	"sync"
 	"time"
 
+	"github.com/somethinghero/leaf/chanrpc"
 	"github.com/somethinghero/leaf/conf"
+	"github.com/somethinghero/leaf/log"
 	"github.com/somethinghero/leaf/network"
 )
 
 var (
-	server  *network.TCPServer
-	clients []*network.TCPClient
+	// the cluster of the process, guarded by mutexRunning
+	server       *network.TCPServer
+	clients      []*network.TCPClient
+	mutexRunning sync.Mutex
+
+	// name -> chanrpc server exposed to other nodes
+	servers = make(map[string]*chanrpc.Server)
+
+	// node name -> agent
+	agents      = make(map[string]*Agent)
+	mutexAgents sync.Mutex
 )
 
-//Init Init
-func Init() {
+//Enabled whether the cluster is configured, Init does nothing if it is not
+func Enabled() bool {
+	return conf.ListenAddr != "" || len(conf.ConnAddrs) > 0
+}
+
+//Init desc:
+// start the cluster of the process, return an error if it is running
+// goroutine safe
+func Init() error {
+	if !Enabled() {
+		return nil
+	}
+	if conf.NodeName == "" {
+		return errors.New("NodeName must not be empty")
+	}
+
+	mutexRunning.Lock()
+	defer mutexRunning.Unlock()
+	if server != nil || clients != nil {
+		return errors.New("cluster is running")
+	}
+
+	var s *network.TCPServer
 	if conf.ListenAddr != "" {
-		server = new(network.TCPServer)
-		server.Addr = conf.ListenAddr
-		server.MaxConnNum = int(math.MaxInt32)
-		server.PendingWriteNum = conf.PendingWriteNum
-		server.LenMsgLen = 4
-		server.MaxMsgLen = math.MaxUint32
-		server.NewAgent = newAgent
+		s = new(network.TCPServer)
+		s.Addr = conf.ListenAddr
+		s.MaxConnNum = int(math.MaxInt32)
+		s.PendingWriteNum = conf.PendingWriteNum
+		s.LenMsgLen = 4
+		s.MaxMsgLen = math.MaxUint32
+		s.NewAgent = func(conn *network.TCPConn) network.Agent {
+			return newAgent(conn, nil)
+		}
+		s.TLS = tlsConfig()
 
-		server.Start()
+		err := s.Start()
+		if err != nil {
+			return fmt.Errorf("cluster listen on %v error: %v", conf.ListenAddr, err)
+		}
 	}
 
+	var cs []*network.TCPClient
 	for _, addr := range conf.ConnAddrs {
 		client := new(network.TCPClient)
 		client.Addr = addr
 		client.ConnNum = 1
 		client.ConnectInterval = 3 * time.Second
 		client.PendingWriteNum = conf.PendingWriteNum
+		client.AutoReconnect = true
 		client.LenMsgLen = 4
 		client.MaxMsgLen = math.MaxUint32
-		client.NewAgent = newAgent
+		client.NewAgent = func(conn *network.TCPConn) network.Agent {
+			return newAgent(conn, client)
+		}
+		client.TLS = tlsConfig()
 
-		client.Start()
-		clients = append(clients, client)
+		err := client.Start()
+		if err != nil {
+			destroy(s, cs)
+			return fmt.Errorf("cluster connect to %v error: %v", addr, err)
+		}
+		cs = append(cs, client)
 	}
+
+	server, clients = s, cs
+	return nil
 }
 
-//Destroy Destroy
-func Destroy() {
-	if server != nil {
-		server.Close()
+// nil if tls is not enabled
+func tlsConfig() *network.TLSConfig {
+	if conf.ClusterCertFile == "" && conf.ClusterCAFile == "" {
+		return nil
 	}
+	return &network.TLSConfig{
+		CertFile:   conf.ClusterCertFile,
+		KeyFile:    conf.ClusterKeyFile,
+		CAFile:     conf.ClusterCAFile,
+		ServerName: conf.ClusterServerName,
+		MinVersion: conf.ClusterTLSMinVersion,
+	}
+}
 
-	for _, client := range clients {
+//Destroy goroutine safe
+func Destroy() {
+	mutexRunning.Lock()
+	s, cs := server, clients
+	server, clients = nil, nil
+	mutexRunning.Unlock()
+
+	destroy(s, cs)
+}
+
+func destroy(s *network.TCPServer, cs []*network.TCPClient) {
+	if s != nil {
+		s.Close()
+	}
+	for _, client := range cs {
 		client.Close()
 	}
 }
 
-//Agent Agent
-type Agent struct {
-	conn *network.TCPConn
+//Register desc:
+// expose a chanrpc server to other nodes under the name
+// you must call the function before calling cluster.Init
+// goroutine not safe
+func Register(name string, s *chanrpc.Server) {
+	if _, ok := servers[name]; ok {
+		log.Fatal("server %v is already registered", name)
+	}
+
+	servers[name] = s
+}
+
+//GetAgent goroutine safe, return nil if the node is not connected
+func GetAgent(name string) *Agent {
+	mutexAgents.Lock()
+	defer mutexAgents.Unlock()
+
+	return agents[name]
+}
+
+//Nodes goroutine safe, names of all connected nodes
+func Nodes() []string {
+	mutexAgents.Lock()
+	defer mutexAgents.Unlock()
+
+	names := make([]string, 0, len(agents))
+	for name := range agents {
+		names = append(names, name)
+	}
+	return names
+}
+
+func getAgent(name string) (*Agent, error) {
+	a := GetAgent(name)
+	if a == nil {
+		return nil, fmt.Errorf("node %v not connected", name)
+	}
+	return a, nil
+}
+
+//Go goroutine safe
+func Go(node string, server string, id interface{}, args ...interface{}) {
+	a, err := getAgent(node)
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+
+	a.Go(server, id, args...)
+}
+
+//Call0 goroutine safe
+func Call0(node string, server string, id interface{}, args ...interface{}) error {
+	a, err := getAgent(node)
+	if err != nil {
+		return err
+	}
+
+	return a.Call0(server, id, args...)
 }
 
-func newAgent(conn *network.TCPConn) network.Agent {
-	a := new(Agent)
-	a.conn = conn
-	return a
+//Call1 goroutine safe
+func Call1(node string, server string, id interface{}, args ...interface{}) (interface{}, error) {
+	a, err := getAgent(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Call1(server, id, args...)
 }
 
-//Run Run
-func (a *Agent) Run() {}
+//CallN goroutine safe
+func CallN(node string, server string, id interface{}, args ...interface{}) ([]interface{}, error) {
+	a, err := getAgent(node)
+	if err != nil {
+		return nil, err
+	}
 
-//OnClose OnClose
-func (a *Agent) OnClose() {}
+	return a.CallN(server, id, args...)
+}
+
+//Call0Context goroutine safe
+func Call0Context(ctx context.Context, node string, server string, id interface{}, args ...interface{}) error {
+	a, err := getAgent(node)
+	if err != nil {
+		return err
+	}
+
+	return a.Call0Context(ctx, server, id, args...)
+}
+
+//Call1Context goroutine safe
+func Call1Context(ctx context.Context, node string, server string, id interface{}, args ...interface{}) (interface{}, error) {
+	a, err := getAgent(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Call1Context(ctx, server, id, args...)
+}
+
+//CallNContext goroutine safe
+func CallNContext(ctx context.Context, node string, server string, id interface{}, args ...interface{}) ([]interface{}, error) {
+	a, err := getAgent(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.CallNContext(ctx, server, id, args...)
+}
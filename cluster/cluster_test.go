@@ -0,0 +1,171 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/chanrpc"
+	"github.com/somethinghero/leaf/cluster"
+	"github.com/somethinghero/leaf/conf"
+)
+
+// the test binary is run again as the other node
+const (
+	envNode       = "LEAF_CLUSTER_TEST_NODE"
+	envListenAddr = "LEAF_CLUSTER_TEST_LISTEN"
+	envConnAddr   = "LEAF_CLUSTER_TEST_CONN"
+)
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(envNode); name != "" {
+		runNode(name, os.Getenv(envListenAddr), os.Getenv(envConnAddr))
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// run until stdin is closed
+func runNode(name, listenAddr, connAddr string) {
+	s := chanrpc.NewServer(10)
+	s.Register("echo", func(args []interface{}) interface{} {
+		return args[0]
+	})
+	s.Register("sleep", func(args []interface{}) {
+		time.Sleep(300 * time.Millisecond)
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+	cluster.Register("test", s)
+
+	conf.NodeName = name
+	conf.ListenAddr = listenAddr
+	conf.ConnAddrs = []string{connAddr}
+	if err := cluster.Init(); err != nil {
+		os.Exit(1)
+	}
+
+	buf := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(buf); err != nil {
+			break
+		}
+	}
+	cluster.Destroy()
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func waitAgent(name string, timeout time.Duration) *cluster.Agent {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if a := cluster.GetAgent(name); a != nil {
+			return a
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func waitListen(addr string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// the nodes dial each other
+func TestTwoNodes(t *testing.T) {
+	addrA := freeAddr(t)
+	addrB := freeAddr(t)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), envNode+"=b", envListenAddr+"="+addrB, envConnAddr+"="+addrA)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+
+	// node b fails to dial node a first, node a dials node b then
+	if !waitListen(addrB, 10*time.Second) {
+		t.Fatal("node b is not listening")
+	}
+	conf.NodeName = "a"
+	conf.ListenAddr = addrA
+	conf.ConnAddrs = []string{addrB}
+	if err := cluster.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Destroy()
+
+	if waitAgent("b", 10*time.Second) == nil {
+		t.Fatal("node b is not connected")
+	}
+
+	// handshake
+	a := cluster.GetAgent("b")
+	if a == nil || a.Name() != "b" {
+		t.Fatalf("agent of node b: %v", a)
+	}
+	if nodes := cluster.Nodes(); len(nodes) != 1 || nodes[0] != "b" {
+		t.Fatalf("nodes: %v", nodes)
+	}
+
+	// calls
+	ret, err := cluster.Call1Context(context.Background(), "b", "test", "echo", "hello")
+	if err != nil || ret != "hello" {
+		t.Fatalf("Call1Context: %v, %v", ret, err)
+	}
+	_, err = cluster.Call1("b", "unknown", "echo", "hello")
+	if err == nil {
+		t.Fatal("Call1 of an unknown server must fail")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err = cluster.Call0Context(ctx, "b", "test", "sleep")
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call0Context: %v", err)
+	}
+	_, err = cluster.Call1Context(context.Background(), "c", "test", "echo", "hello")
+	if err == nil {
+		t.Fatal("Call1Context of an unknown node must fail")
+	}
+
+	// duplicate: only one connection is kept and the other one does not
+	// reconnect in a loop
+	time.Sleep(4 * time.Second)
+	if cluster.GetAgent("b") != a {
+		t.Fatal("the connection to node b is replaced")
+	}
+	ret, err = cluster.Call1Context(context.Background(), "b", "test", "echo", "again")
+	if err != nil || ret != "again" {
+		t.Fatalf("Call1Context: %v, %v", ret, err)
+	}
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// message types
+const (
+	msgHandshake = iota
+	msgCall
+	msgRet
+)
+
+// return shapes of msgCall, same as chanrpc
+const (
+	callGo = -1
+	call0  = 0
+	call1  = 1
+	callN  = 2
+)
+
+//message format:
+// ----------------------
+// | len | gob(message) |
+// ----------------------
+type message struct {
+	Type uint8
+	Seq  uint32
+
+	// handshake
+	Name string
+
+	// call
+	Server string
+	ID     interface{}
+	Args   []interface{}
+	N      int
+
+	// ret
+	Ret interface{}
+	Err string
+}
+
+func init() {
+	RegisterType([]interface{}(nil))
+}
+
+//RegisterType desc:
+// register the concrete type of a value passed as an argument or returned
+// by a remote call, it must be registered on both nodes (see gob.Register)
+func RegisterType(value interface{}) {
+	gob.Register(value)
+}
+
+func encode(msg *message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte) (*message, error) {
+	msg := new(message)
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -1,27 +1,69 @@
 package conf
 
+import (
+	"time"
+)
+
 var (
 	//LenStackBuf LenStackBuf
 	LenStackBuf = 4096
 
+	//ShutdownTimeout total time for all modules to stop, 0 means no limit
+	ShutdownTimeout = 30 * time.Second
+
 	//LogLevel log
 	LogLevel string
 	//LogPath LogPath
 	LogPath string
 	//LogFlag LogFlag
 	LogFlag int
+	//LogFormat "text" (default) or "json"
+	LogFormat string
+	//LogMaxSize rotate log files larger than LogMaxSize megabytes, 0 means no limit
+	LogMaxSize int
+	//LogDaily rotate log files at midnight
+	LogDaily bool
+	//LogMaxBackups number of rotated log files to keep, 0 means no limit
+	LogMaxBackups int
+	//LogMaxAge remove rotated log files older than LogMaxAge, 0 means no limit
+	LogMaxAge time.Duration
+	//LogCompress gzip rotated log files
+	LogCompress bool
 
-	//ConsolePort console
+	//MsgCipher cipher of protobuf message bodies: "xxtea" (default), "aes-gcm" or "none"
+	MsgCipher string
+	//MsgCryptKey key of MsgCipher, 16, 24 or 32 bytes for aes-gcm, empty means the key of earlier versions for xxtea
+	MsgCryptKey string
+
+	//DisableConsole console, do not start the console even if ConsolePort is set
+	DisableConsole bool
+	//ConsolePort ConsolePort
 	ConsolePort int
 	//ConsolePrompt ConsolePrompt
 	ConsolePrompt = "Leaf# "
 	//ProfilePath ProfilePath
 	ProfilePath string
 
-	//ListenAddr cluster
+	//DisableCluster cluster, do not start the cluster even if ListenAddr or ConnAddrs is set
+	DisableCluster bool
+	//NodeName unique name of this node
+	NodeName string
+	//ListenAddr ListenAddr
 	ListenAddr string
 	//ConnAddrs ConnAddrs
 	ConnAddrs []string
 	//PendingWriteNum PendingWriteNum
 	PendingWriteNum int
+
+	//ClusterCertFile tls of cluster links, enabled if ClusterCertFile or ClusterCAFile is set
+	// the certificate of this node, used by both the server and the clients
+	ClusterCertFile string
+	//ClusterKeyFile ClusterKeyFile
+	ClusterKeyFile string
+	//ClusterCAFile verify the other nodes with the CA certificates, mTLS if set on the server
+	ClusterCAFile string
+	//ClusterServerName the name of the other nodes to verify, default to the host of ConnAddrs
+	ClusterServerName string
+	//ClusterTLSMinVersion "1.0", "1.1", "1.2" (default) or "1.3"
+	ClusterTLSMinVersion string
 )
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"runtime/pprof"
+	"sync"
 	"time"
 
 	"github.com/somethinghero/leaf/chanrpc"
@@ -12,11 +13,14 @@ import (
 	"github.com/somethinghero/leaf/log"
 )
 
-var commands = []Command{
-	new(CommandHelp),
-	new(CommandCPUProf),
-	new(CommandProf),
-}
+var (
+	commands = []Command{
+		new(CommandHelp),
+		new(CommandCPUProf),
+		new(CommandProf),
+	}
+	mutexCommands sync.RWMutex
+)
 
 //Command Command
 type Command interface {
@@ -61,14 +65,31 @@ func (c *ExternalCommand) run(_args []string) string {
 	return output
 }
 
+//FuncCommand command run in the console goroutine
+type FuncCommand struct {
+	_name string
+	_help string
+	f     func(args []string) string
+}
+
+func (c *FuncCommand) name() string {
+	return c._name
+}
+
+func (c *FuncCommand) help() string {
+	return c._help
+}
+
+func (c *FuncCommand) run(args []string) string {
+	return c.f(args)
+}
+
 //Register desc:
 // you must call the function before calling console.Init
 // goroutine not safe
 func Register(name string, help string, f interface{}, server *chanrpc.Server) {
-	for _, c := range commands {
-		if c.name() == name {
-			log.Fatal("command %v is already registered", name)
-		}
+	if getCommand(name) != nil {
+		log.Fatal("command %v is already registered", name)
 	}
 
 	server.Register(name, f)
@@ -77,9 +98,69 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	c._name = name
 	c._help = help
 	c.server = server
+	addCommand(c)
+}
+
+//RegisterFunc desc:
+// f must be goroutine safe, it is called in the console goroutine
+// return an error if the name is already registered
+// goroutine safe
+func RegisterFunc(name string, help string, f func(args []string) string) error {
+	c := new(FuncCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+
+	mutexCommands.Lock()
+	defer mutexCommands.Unlock()
+	for _, _c := range commands {
+		if _c.name() == name {
+			return fmt.Errorf("command %v is already registered", name)
+		}
+	}
+	commands = append(commands, c)
+	return nil
+}
+
+//Unregister goroutine safe
+func Unregister(name string) {
+	mutexCommands.Lock()
+	defer mutexCommands.Unlock()
+
+	for i, c := range commands {
+		if c.name() == name {
+			commands = append(commands[:i:i], commands[i+1:]...)
+			return
+		}
+	}
+}
+
+func addCommand(c Command) {
+	mutexCommands.Lock()
+	defer mutexCommands.Unlock()
+
 	commands = append(commands, c)
 }
 
+func getCommand(name string) Command {
+	mutexCommands.RLock()
+	defer mutexCommands.RUnlock()
+
+	for _, c := range commands {
+		if c.name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func getCommands() []Command {
+	mutexCommands.RLock()
+	defer mutexCommands.RUnlock()
+
+	return commands
+}
+
 //CommandHelp help
 type CommandHelp struct{}
 
@@ -93,7 +174,7 @@ func (c *CommandHelp) help() string {
 
 func (c *CommandHelp) run([]string) string {
 	output := "Commands:\r\n"
-	for _, c := range commands {
+	for _, c := range getCommands() {
 		output += c.name() + " - " + c.help() + "\r\n"
 	}
 	output += "quit - exit console"
@@ -2,35 +2,65 @@ package console
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/somethinghero/leaf/conf"
 	"github.com/somethinghero/leaf/network"
 )
 
-var server *network.TCPServer
+// the console of the process
+var (
+	server      *network.TCPServer
+	mutexServer sync.Mutex
+)
+
+//Enabled whether the console is configured, Init does nothing if it is not
+func Enabled() bool {
+	return conf.ConsolePort != 0
+}
+
+//Init desc:
+// start the console of the process, return an error if it is running
+// goroutine safe
+func Init() error {
+	if !Enabled() {
+		return nil
+	}
 
-//Init Init
-func Init() {
-	if conf.ConsolePort == 0 {
-		return
+	mutexServer.Lock()
+	defer mutexServer.Unlock()
+	if server != nil {
+		return errors.New("console is running")
 	}
 
-	server = new(network.TCPServer)
-	server.Addr = "localhost:" + strconv.Itoa(conf.ConsolePort)
-	server.MaxConnNum = int(math.MaxInt32)
-	server.PendingWriteNum = 100
-	server.NewAgent = newAgent
+	s := new(network.TCPServer)
+	s.Addr = "localhost:" + strconv.Itoa(conf.ConsolePort)
+	s.MaxConnNum = int(math.MaxInt32)
+	s.PendingWriteNum = 100
+	s.NewAgent = newAgent
 
-	server.Start()
+	err := s.Start()
+	if err != nil {
+		return fmt.Errorf("console listen on port %v error: %v", conf.ConsolePort, err)
+	}
+	server = s
+	return nil
 }
 
-//Destroy Destroy
+//Destroy goroutine safe
 func Destroy() {
-	if server != nil {
-		server.Close()
+	mutexServer.Lock()
+	s := server
+	server = nil
+	mutexServer.Unlock()
+
+	if s != nil {
+		s.Close()
 	}
 }
 
@@ -67,13 +97,7 @@ func (a *Agent) Run() {
 		if args[0] == "quit" {
 			break
 		}
-		var c Command
-		for _, _c := range commands {
-			if _c.name() == args[0] {
-				c = _c
-				break
-			}
-		}
+		c := getCommand(args[0])
 		if c == nil {
 			a.conn.Write([]byte("command not found, try `help` for help\r\n"))
 			continue
@@ -0,0 +1,72 @@
+package leaf_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/somethinghero/leaf"
+)
+
+type echo struct {
+	name string
+	deps []string
+}
+
+func (m *echo) Name() string {
+	return m.name
+}
+
+func (m *echo) Dependencies() []string {
+	return m.deps
+}
+
+func (m *echo) OnInit() {
+	fmt.Println(m.name, "init")
+}
+
+func (m *echo) OnDestroy() {
+	fmt.Println(m.name, "destroy")
+}
+
+func (m *echo) Run(closeSig chan bool) {
+	<-closeSig
+}
+
+func ExampleApp() {
+	// m1 is initialized after m2
+	app := leaf.NewApp(&echo{"m1", []string{"m2"}}, &echo{"m2", nil})
+	app.DisableConsole = true
+	app.DisableCluster = true
+
+	err := app.Start(context.Background())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	state, _ := app.Modules().State("m1")
+	fmt.Println("m1", state)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		app.Stop(ctx)
+	}()
+	app.Wait()
+
+	// Output:
+	// m2 init
+	// m1 init
+	// m1 running
+	// m1 destroy
+	// m2 destroy
+}
+
+func ExampleApp_cycle() {
+	app := leaf.NewApp(&echo{"m1", []string{"m2"}}, &echo{"m2", []string{"m1"}})
+	fmt.Println(app.Start(context.Background()))
+
+	// Output:
+	// module dependency cycle: m1 -> m2 -> m1
+}
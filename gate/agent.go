@@ -13,4 +13,10 @@ type Agent interface {
 	Destroy()
 	UserData() interface{}
 	SetUserData(data interface{})
+	// CloseUnknown until the agent is being closed
+	CloseReason() CloseReason
+	// the seq of the last message acked by the client, 0 without Gate.SeqAck
+	LastAcked() uint32
+	// nil if not bound, see Gate.Bind
+	UserID() interface{}
 }
@@ -1,8 +1,12 @@
 package gate
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"net"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/somethinghero/leaf/chanrpc"
@@ -20,25 +24,92 @@ type Gate struct {
 	KCPProcessor    network.Processor
 	KCPAgentChanRPC *chanrpc.Server
 
+	// time for pending writes to be flushed on shutdown, default 5s
+	DrainTimeout time.Duration
+
+	// interceptors of messages of all agents, the first is the outermost
+	Inbound  []InboundInterceptor
+	Outbound []OutboundInterceptor
+
+	// limits of messages read from every agent, nil means no limit
+	RateLimit *RateLimit
+
+	// close agents without messages read for IdleTimeout, 0 means no timeout
+	// the reason passed to CloseAgent is CloseTimeout
+	IdleTimeout time.Duration
+	// heartbeat: a message equal to PingData is answered with PongData
+	// instead of being unmarshaled, nil means no heartbeat
+	PingData []byte
+	PongData []byte
+
+	// add a header | seq(4 bytes) | ack(4 bytes) | to every message and drop
+	// duplicate messages read, see seqAckLen
+	SeqAck bool
+
+	// nil means no session, see Session
+	Session      *Session
+	sessions     map[string]*agent
+	sessionMutex sync.Mutex
+
+	// agents bound to user ids, see Bind
+	LoginPolicy   LoginPolicy
+	registry      map[interface{}][]*agent
+	registryMutex sync.Mutex
+
 	// websocket
 	WSAddr      string
 	HTTPTimeout time.Duration
 	CertFile    string
 	KeyFile     string
+	WSFraming   network.WSFraming
 
 	// tcp
 	TCPAddr      string
 	LenMsgLen    int
 	LittleEndian bool
+	// nil means no tls
+	TCPTLS *network.TLSConfig
 
 	//kcp
 	KCPAddr         string
 	KCPLenMsgLen    int
 	KCPLittleEndian bool
+	// nil means network.DefaultKCPConfig
+	KCPConfig *network.KCPConfig
+
+	started   bool
+	wsServer  *network.WSServer
+	tcpServer *network.TCPServer
+	kcpServer *network.KCPServer
 }
 
-//Run Run
-func (gate *Gate) Run(closeSig chan bool) {
+//Start desc:
+// start the servers, called by the module manager before Run
+// return an error if a server fails to listen
+func (gate *Gate) Start() error {
+	if gate.started {
+		return nil
+	}
+	if gate.PendingWriteNum <= 0 {
+		gate.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", gate.PendingWriteNum)
+	}
+	if gate.Session != nil {
+		if gate.Session.GracePeriod <= 0 {
+			gate.Session.GracePeriod = time.Minute
+			log.Release("invalid GracePeriod, reset to %v", gate.Session.GracePeriod)
+		}
+		if gate.Session.BufferSize <= 0 {
+			gate.Session.BufferSize = gate.PendingWriteNum - 1
+			log.Release("invalid BufferSize, reset to %v", gate.Session.BufferSize)
+		}
+		// the replay and the frameResumed are written at once
+		if gate.Session.BufferSize <= 0 || gate.Session.BufferSize >= gate.PendingWriteNum {
+			return fmt.Errorf("session BufferSize %v must be in [1, PendingWriteNum %v)",
+				gate.Session.BufferSize, gate.PendingWriteNum)
+		}
+	}
+
 	var wsServer *network.WSServer
 	if gate.WSAddr != "" {
 		wsServer = new(network.WSServer)
@@ -47,17 +118,15 @@ func (gate *Gate) Run(closeSig chan bool) {
 		wsServer.PendingWriteNum = gate.PendingWriteNum
 		wsServer.MaxMsgLen = gate.MaxMsgLen
 		wsServer.HTTPTimeout = gate.HTTPTimeout
+		wsServer.IdleTimeout = gate.IdleTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
 		wsServer.LenMsgLen = gate.LenMsgLen
 		wsServer.MaxMsgLen = gate.MaxMsgLen
 		wsServer.LittleEndian = gate.LittleEndian
+		wsServer.Framing = gate.WSFraming
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := &agent{conn: conn, processor: gate.Processor, rpc: gate.AgentChanRPC}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.accept(conn, gate.Processor, gate.AgentChanRPC, "NewAgent", gate.LittleEndian)
 		}
 	}
 
@@ -67,15 +136,13 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.Addr = gate.TCPAddr
 		tcpServer.MaxConnNum = gate.MaxConnNum
 		tcpServer.PendingWriteNum = gate.PendingWriteNum
+		tcpServer.IdleTimeout = gate.IdleTimeout
 		tcpServer.LenMsgLen = gate.LenMsgLen
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
+		tcpServer.TLS = gate.TCPTLS
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, processor: gate.Processor, rpc: gate.AgentChanRPC}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.accept(conn, gate.Processor, gate.AgentChanRPC, "NewAgent", gate.LittleEndian)
 		}
 	}
 
@@ -85,64 +152,233 @@ func (gate *Gate) Run(closeSig chan bool) {
 		kcpServer.Addr = gate.KCPAddr
 		kcpServer.MaxConnNum = gate.MaxConnNum
 		kcpServer.PendingWriteNum = gate.PendingWriteNum
+		kcpServer.IdleTimeout = gate.IdleTimeout
 		kcpServer.LenMsgLen = gate.KCPLenMsgLen
 		kcpServer.MaxMsgLen = gate.MaxMsgLen
 		kcpServer.LittleEndian = gate.KCPLittleEndian
+		kcpServer.Config = gate.KCPConfig
 		kcpServer.NewAgent = func(conn *network.KCPConn) network.Agent {
-			a := &agent{conn: conn, processor: gate.KCPProcessor, rpc: gate.KCPAgentChanRPC}
-			if gate.KCPAgentChanRPC != nil {
-				gate.KCPAgentChanRPC.Go("NewKCPAgent", a)
-			}
-			return a
+			return gate.accept(conn, gate.KCPProcessor, gate.KCPAgentChanRPC, "NewKCPAgent", gate.KCPLittleEndian)
 		}
 	}
 
 	if wsServer != nil {
-		wsServer.Start()
+		err := wsServer.Start()
+		if err != nil {
+			return fmt.Errorf("gate listen on %v error: %v", gate.WSAddr, err)
+		}
 	}
 	if tcpServer != nil {
-		tcpServer.Start()
+		err := tcpServer.Start()
+		if err != nil {
+			if wsServer != nil {
+				wsServer.Close()
+			}
+			return fmt.Errorf("gate listen on %v error: %v", gate.TCPAddr, err)
+		}
 	}
 	if kcpServer != nil {
-		kcpServer.Start()
+		err := kcpServer.Start()
+		if err != nil {
+			if wsServer != nil {
+				wsServer.Close()
+			}
+			if tcpServer != nil {
+				tcpServer.Close()
+			}
+			return fmt.Errorf("gate listen on %v error: %v", gate.KCPAddr, err)
+		}
 	}
+
+	gate.started = true
+	gate.wsServer = wsServer
+	gate.tcpServer = tcpServer
+	gate.kcpServer = kcpServer
+	return nil
+}
+
+//Run Run
+func (gate *Gate) Run(closeSig chan bool) {
+	// not run by the module manager
+	if !gate.started {
+		err := gate.Start()
+		if err != nil {
+			log.Error("%v", err)
+			return
+		}
+	}
+	wsServer := gate.wsServer
+	tcpServer := gate.tcpServer
+	kcpServer := gate.kcpServer
 	<-closeSig
+
+	// every server stops accepting connections and then drains its connections
+	drainTimeout := gate.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = 5 * time.Second
+	}
+	var wg sync.WaitGroup
 	if wsServer != nil {
-		wsServer.Close()
+		wg.Add(1)
+		go func() {
+			wsServer.Shutdown(drainTimeout)
+			wg.Done()
+		}()
 	}
 	if tcpServer != nil {
-		tcpServer.Close()
+		wg.Add(1)
+		go func() {
+			tcpServer.Shutdown(drainTimeout)
+			wg.Done()
+		}()
 	}
 	if kcpServer != nil {
-		kcpServer.Close()
+		wg.Add(1)
+		go func() {
+			kcpServer.Shutdown(drainTimeout)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	gate.closeSessions()
+
+	gate.started = false
+	gate.wsServer = nil
+	gate.tcpServer = nil
+	gate.kcpServer = nil
+}
+
+// the network agent of conn, newAgent is the chanrpc called with a new agent
+func (gate *Gate) accept(conn network.Conn, processor network.Processor, rpc *chanrpc.Server, newAgent string, littleEndian bool) network.Agent {
+	if gate.Session != nil {
+		return &sessionConn{
+			gate:         gate,
+			conn:         conn,
+			processor:    processor,
+			rpc:          rpc,
+			littleEndian: littleEndian,
+			newAgent:     newAgent,
+		}
+	}
+
+	a := gate.newAgent(conn, processor, rpc, littleEndian)
+	if rpc != nil {
+		rpc.Go(newAgent, a)
 	}
+	return a
+}
+
+func (gate *Gate) newAgent(conn network.Conn, processor network.Processor, rpc *chanrpc.Server, littleEndian bool) *agent {
+	a := &agent{gate: gate, conn: conn, processor: processor, rpc: rpc, littleEndian: littleEndian}
+	a.inbound = chainInbound(gate.Inbound, a.route)
+	a.outbound = chainOutbound(gate.Outbound, a.write)
+	a.limiter = newRateLimiter(gate.RateLimit)
+	return a
 }
 
 //OnDestroy OnDestroy
 func (gate *Gate) OnDestroy() {}
 
 type agent struct {
-	conn      network.Conn
-	processor network.Processor
-	rpc       *chanrpc.Server
-	userData  interface{}
+	gate         *Gate
+	processor    network.Processor
+	rpc          *chanrpc.Server
+	littleEndian bool
+	inbound      InboundHandler
+	outbound     OutboundHandler
+	limiter      *rateLimiter
+	userData     interface{}
+	closeReason  CloseReason
+
+	// conn is replaced when a session is resumed
+	mutex   sync.Mutex
+	conn    network.Conn
+	session *session
+	// seq of the last message written, guarded by mutex
+	sent uint32
+	// seq of the last message read and acked by the client
+	received uint32
+	acked    uint32
+	// groups joined, guarded by mutex, no more groups after the agent is closed
+	groups map[*Group]struct{}
+	closed bool
+	// the user id bound, guarded by the registry mutex of gate
+	uid   interface{}
+	bound bool
 }
 
 func (a *agent) Run() {
+	a.serve(a.conn)
+}
+
+// the read loop of conn
+func (a *agent) serve(conn network.Conn) {
 	for {
-		data, err := a.conn.ReadMsg()
+		data, err := conn.ReadMsg()
 		if err != nil {
+			a.setConnCloseReason(conn, readErrReason(conn, err))
 			log.Debug("read message: %v", err)
 			break
 		}
+		if a.session != nil {
+			if len(data) == 0 || data[0] != frameData {
+				a.setConnCloseReason(conn, CloseProtocol)
+				log.Debug("invalid session frame")
+				break
+			}
+			data = data[1:]
+		}
+		if a.gate.SeqAck {
+			var dup bool
+			data, dup, err = a.readSeqAck(data)
+			if err != nil {
+				a.setConnCloseReason(conn, CloseProtocol)
+				log.Debug("read seq ack: %v", err)
+				break
+			}
+			if dup {
+				continue
+			}
+		}
+		// a heartbeat is not rate limited
+		if a.gate.PingData != nil && bytes.Equal(data, a.gate.PingData) {
+			if a.gate.PongData != nil {
+				conn.WriteMsg(a.frame(0, a.gate.PongData)...)
+			}
+			continue
+		}
+		if a.limiter != nil {
+			ok, err := a.limit(a.limiter.takeData(data))
+			if err != nil {
+				a.setConnCloseReason(conn, CloseRateLimited)
+				log.Debug("rate limit: %v", err)
+				break
+			}
+			if !ok {
+				continue
+			}
+		}
 		if a.processor != nil {
 			msg, err := a.processor.Unmarshal(data)
 			if err != nil {
+				a.setConnCloseReason(conn, CloseProtocol)
 				log.Debug("unmarshal message error: %v", err)
 				break
 			}
-			err = a.processor.Route(msg, a)
+			if a.limiter != nil {
+				ok, err := a.limit(a.limiter.takeMsg(msg))
+				if err != nil {
+					a.setConnCloseReason(conn, CloseRateLimited)
+					log.Debug("rate limit %v: %v", reflect.TypeOf(msg), err)
+					break
+				}
+				if !ok {
+					continue
+				}
+			}
+			err = a.inbound(a, msg)
 			if err != nil {
+				a.setConnCloseReason(conn, CloseProtocol)
 				log.Debug("route message error: %v", err)
 				break
 			}
@@ -150,42 +386,108 @@ func (a *agent) Run() {
 	}
 }
 
+// return false to drop the message
+func (a *agent) limit(limited bool, err error) (bool, error) {
+	if !limited {
+		return true, nil
+	}
+	if a.rpc != nil && a.limiter.notify(err) {
+		a.rpc.Go("RateLimited", a, a.limiter.config.Action)
+	}
+	if err != nil {
+		return false, err
+	}
+	return a.limiter.config.Action != RateLimitDrop, nil
+}
+
 func (a *agent) OnClose() {
+	a.leaveGroups()
+	a.gate.Unbind(a)
 	if a.rpc != nil {
-		err := a.rpc.Call0("CloseAgent", a)
+		err := a.rpc.Call0("CloseAgent", a, a.CloseReason())
 		if err != nil {
 			log.Error("chanrpc error: %v", err)
 		}
 	}
 }
 
+func (a *agent) route(_ Agent, msg interface{}) error {
+	return a.processor.Route(msg, a)
+}
+
 func (a *agent) WriteMsg(msg interface{}) {
 	if a.processor != nil {
-		data, err := a.processor.Marshal(msg)
-		if err != nil {
-			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
-			return
-		}
-		err = a.conn.WriteMsg(data...)
-		if err != nil {
+		err := a.outbound(a, msg)
+		switch {
+		case err == nil:
+		case errors.Is(err, network.ErrConnClosed), errors.Is(err, errSessionClosed):
+			// the agent is closing
+		case errors.Is(err, network.ErrWriteChannelFull):
+			// once, the agent is closed with CloseWriteChannelFull
+			log.Debug("write message %v error: %v", reflect.TypeOf(msg), err)
+		default:
 			log.Error("write message %v error: %v", reflect.TypeOf(msg), err)
 		}
 	}
 }
 
+func (a *agent) write(_ Agent, msg interface{}) error {
+	data, err := a.processor.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal error: %v", err)
+	}
+	return a.writeData(data)
+}
+
+// write marshaled data
+func (a *agent) writeData(data [][]byte) error {
+	if a.session != nil {
+		return a.writeSession(data)
+	}
+	if a.gate.SeqAck {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		a.sent++
+		return a.conn.WriteMsg(a.frame(a.sent, data...)...)
+	}
+	return a.conn.WriteMsg(data...)
+}
+
+// the latest conn in session mode
+func (a *agent) getConn() network.Conn {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.conn
+}
+
 func (a *agent) LocalAddr() net.Addr {
-	return a.conn.LocalAddr()
+	return a.getConn().LocalAddr()
 }
 
 func (a *agent) RemoteAddr() net.Addr {
-	return a.conn.RemoteAddr()
+	return a.getConn().RemoteAddr()
 }
 
 func (a *agent) Close() {
+	a.closeWith(CloseKicked)
+}
+
+// close the agent with reason
+func (a *agent) closeWith(reason CloseReason) {
+	if a.session != nil {
+		a.closeSession(false, reason)
+		return
+	}
+	a.setCloseReason(reason)
 	a.conn.Close()
 }
 
 func (a *agent) Destroy() {
+	if a.session != nil {
+		a.closeSession(true, CloseKicked)
+		return
+	}
+	a.setCloseReason(CloseKicked)
 	a.conn.Destroy()
 }
 
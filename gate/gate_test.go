@@ -0,0 +1,73 @@
+package gate_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/somethinghero/leaf/gate"
+	"github.com/somethinghero/leaf/network"
+)
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// the servers started are closed if another server fails
+func TestGateStartError(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	tests := []struct {
+		name   string
+		config func(g *gate.Gate)
+	}{
+		{"ws listen", func(g *gate.Gate) {
+			g.WSAddr = busy.Addr().String()
+		}},
+		{"ws cert", func(g *gate.Gate) {
+			g.CertFile = "nonexistent.crt"
+			g.KeyFile = "nonexistent.key"
+		}},
+		{"tcp listen", func(g *gate.Gate) {
+			g.TCPAddr = busy.Addr().String()
+		}},
+		{"kcp crypt", func(g *gate.Gate) {
+			g.KCPConfig = &network.KCPConfig{Crypt: "rot13", CryptKey: "leaf"}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := &gate.Gate{
+				MaxConnNum: 10,
+				MaxMsgLen:  4096,
+				WSAddr:     freeAddr(t),
+				TCPAddr:    freeAddr(t),
+				KCPAddr:    "127.0.0.1:0",
+			}
+			test.config(g)
+			if err := g.Start(); err == nil {
+				t.Fatal("started")
+			}
+
+			for _, addr := range []string{g.WSAddr, g.TCPAddr} {
+				if addr == busy.Addr().String() {
+					continue
+				}
+				ln, err := net.Listen("tcp", addr)
+				if err != nil {
+					t.Fatalf("the server on %v is not closed: %v", addr, err)
+				}
+				ln.Close()
+			}
+		})
+	}
+}
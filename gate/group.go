@@ -0,0 +1,200 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/somethinghero/leaf/network"
+)
+
+//Group desc:
+// a set of agents to broadcast messages to
+// an agent leaves its groups when it is closed
+// goroutine safe
+type Group struct {
+	mutex  sync.RWMutex
+	agents map[*agent]struct{}
+}
+
+//NewGroup NewGroup
+func NewGroup() *Group {
+	g := new(Group)
+	g.agents = make(map[*agent]struct{})
+	return g
+}
+
+//Add an agent of a gate, closed agents are ignored
+func (g *Group) Add(a Agent) {
+	ag, ok := a.(*agent)
+	if !ok {
+		return
+	}
+
+	ag.mutex.Lock()
+	defer ag.mutex.Unlock()
+	if ag.closed {
+		return
+	}
+	if ag.groups == nil {
+		ag.groups = make(map[*Group]struct{})
+	}
+	ag.groups[g] = struct{}{}
+
+	g.mutex.Lock()
+	g.agents[ag] = struct{}{}
+	g.mutex.Unlock()
+}
+
+//Remove Remove
+func (g *Group) Remove(a Agent) {
+	ag, ok := a.(*agent)
+	if !ok {
+		return
+	}
+
+	ag.mutex.Lock()
+	defer ag.mutex.Unlock()
+	delete(ag.groups, g)
+
+	g.mutex.Lock()
+	delete(g.agents, ag)
+	g.mutex.Unlock()
+}
+
+//Has Has
+func (g *Group) Has(a Agent) bool {
+	ag, ok := a.(*agent)
+	if !ok {
+		return false
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	_, ok = g.agents[ag]
+	return ok
+}
+
+//Len Len
+func (g *Group) Len() int {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return len(g.agents)
+}
+
+//Range call f for every agent
+func (g *Group) Range(f func(a Agent)) {
+	for _, a := range g.members() {
+		f(a)
+	}
+}
+
+func (g *Group) members() []*agent {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	agents := make([]*agent, 0, len(g.agents))
+	for a := range g.agents {
+		agents = append(agents, a)
+	}
+	return agents
+}
+
+//BroadcastError the agents failed to write a broadcast message
+type BroadcastError struct {
+	Errors map[Agent]error
+}
+
+func (e *BroadcastError) Error() string {
+	for _, err := range e.Errors {
+		return fmt.Sprintf("broadcast to %v agents error: %v", len(e.Errors), err)
+	}
+	return "broadcast error"
+}
+
+type marshaled struct {
+	data [][]byte
+	err  error
+}
+
+//Broadcast desc:
+// write msg to every agent through the outbound interceptors of its gate,
+// msg is marshaled once by every processor of the agents unless an
+// interceptor passes on another message
+// return a *BroadcastError with the error of every agent failed, agents
+// being closed are ignored
+func (g *Group) Broadcast(msg interface{}) error {
+	return g.BroadcastExcept(msg, nil)
+}
+
+//BroadcastExcept write msg to every agent except the given one
+func (g *Group) BroadcastExcept(msg interface{}, except Agent) error {
+	cache := make(map[network.Processor]marshaled)
+	write := func(a Agent, m interface{}) error {
+		ag := a.(*agent)
+		if len(ag.gate.Outbound) > 0 && !sameMsg(m, msg) {
+			return ag.write(a, m)
+		}
+		d, ok := cache[ag.processor]
+		if !ok {
+			d.data, d.err = ag.processor.Marshal(msg)
+			if d.err != nil {
+				d.err = fmt.Errorf("marshal error: %v", d.err)
+			}
+			cache[ag.processor] = d
+		}
+		if d.err != nil {
+			return d.err
+		}
+		return ag.writeData(d.data)
+	}
+
+	outbound := make(map[*Gate]OutboundHandler)
+	var errs map[Agent]error
+	for _, a := range g.members() {
+		if a.processor == nil || Agent(a) == except {
+			continue
+		}
+		h, ok := outbound[a.gate]
+		if !ok {
+			h = chainOutbound(a.gate.Outbound, write)
+			outbound[a.gate] = h
+		}
+		err := h(a, msg)
+		if err == nil || errors.Is(err, network.ErrConnClosed) || errors.Is(err, errSessionClosed) {
+			continue
+		}
+		if errs == nil {
+			errs = make(map[Agent]error)
+		}
+		errs[a] = err
+	}
+	if errs != nil {
+		return &BroadcastError{Errors: errs}
+	}
+	return nil
+}
+
+// whether m is msg passed on by the interceptors, only pointers are compared
+func sameMsg(m, msg interface{}) bool {
+	if reflect.TypeOf(m) != reflect.TypeOf(msg) {
+		return false
+	}
+	v1, v2 := reflect.ValueOf(m), reflect.ValueOf(msg)
+	return v1.Kind() == reflect.Ptr && v1.Pointer() == v2.Pointer()
+}
+
+// called when the agent is closed
+func (a *agent) leaveGroups() {
+	a.mutex.Lock()
+	groups := a.groups
+	a.groups = nil
+	a.closed = true
+	a.mutex.Unlock()
+
+	for g := range groups {
+		g.mutex.Lock()
+		delete(g.agents, a)
+		g.mutex.Unlock()
+	}
+}
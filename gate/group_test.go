@@ -0,0 +1,97 @@
+package gate_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/gate"
+)
+
+func readPlainEcho(t *testing.T, conn net.Conn) int {
+	msg := readFrame(t, conn)
+	var m map[string]Echo
+	if err := json.Unmarshal(msg, &m); err != nil {
+		t.Fatalf("%q: %v", msg, err)
+	}
+	return m["Echo"].N
+}
+
+func noFrame(t *testing.T, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("unexpected message")
+	}
+}
+
+func TestGroupBroadcast(t *testing.T) {
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Outbound = []gate.OutboundInterceptor{
+			func(a gate.Agent, msg interface{}, next gate.OutboundHandler) error {
+				switch a.UserData() {
+				case "muted":
+					return nil
+				case "replaced":
+					if echo, ok := msg.(*Echo); ok {
+						return next(a, &Echo{N: echo.N + 100})
+					}
+				}
+				return next(a, msg)
+			},
+		}
+	})
+
+	group := gate.NewGroup()
+	var conns []net.Conn
+	var agents []gate.Agent
+	for _, userData := range []string{"", "muted", "replaced", "except"} {
+		conn := dial(t, tg.TCPAddr)
+		a := tg.agent(t)
+		a.SetUserData(userData)
+		group.Add(a)
+		conns = append(conns, conn)
+		agents = append(agents, a)
+	}
+	if group.Len() != 4 {
+		t.Fatalf("len %v", group.Len())
+	}
+
+	if err := group.BroadcastExcept(&Echo{N: 1}, agents[3]); err != nil {
+		t.Fatal(err)
+	}
+	if n := readPlainEcho(t, conns[0]); n != 1 {
+		t.Fatalf("echo %v", n)
+	}
+	if n := readPlainEcho(t, conns[2]); n != 101 {
+		t.Fatalf("echo %v", n)
+	}
+	noFrame(t, conns[1])
+	noFrame(t, conns[3])
+
+	// the error of every agent, the muted one drops the message
+	err := group.Broadcast(&struct{}{})
+	berr, ok := err.(*gate.BroadcastError)
+	if !ok {
+		t.Fatalf("%v", err)
+	}
+	if len(berr.Errors) != 3 || berr.Errors[agents[1]] != nil {
+		t.Fatalf("%v", berr.Errors)
+	}
+
+	// closed agents leave the group
+	agents[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for group.Has(agents[0]) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if group.Has(agents[0]) || group.Len() != 3 {
+		t.Fatalf("len %v", group.Len())
+	}
+	if err := group.Broadcast(&Echo{N: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if n := readPlainEcho(t, conns[3]); n != 2 {
+		t.Fatalf("echo %v", n)
+	}
+}
@@ -0,0 +1,124 @@
+package gate_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	kcp "github.com/somethinghero/kcp-go"
+	"github.com/somethinghero/leaf/gate"
+	"github.com/somethinghero/leaf/network"
+)
+
+func freeUDPAddr(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+// a client of one of the servers of the gate
+type testClient struct {
+	write func(data []byte)
+	read  func() []byte
+}
+
+func dialTCP(t *testing.T, tg *testGate) *testClient {
+	conn := dial(t, tg.TCPAddr)
+	return &testClient{
+		write: func(data []byte) { writeFrame(t, conn, data) },
+		read:  func() []byte { return readFrame(t, conn) },
+	}
+}
+
+func dialWS(t *testing.T, tg *testGate) *testClient {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+tg.WSAddr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{
+		write: func(data []byte) {
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				t.Fatal(err)
+			}
+		},
+		read: func() []byte {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		},
+	}
+}
+
+func dialKCP(t *testing.T, tg *testGate) *testClient {
+	conn, err := kcp.DialWithOptions(tg.KCPAddr, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{
+		write: func(data []byte) { writeFrame(t, conn, data) },
+		read:  func() []byte { return readFrame(t, conn) },
+	}
+}
+
+// heartbeats keep an agent open and are not rate limited, an idle agent is
+// closed with CloseTimeout
+func TestHeartbeat(t *testing.T) {
+	tests := []struct {
+		name string
+		dial func(t *testing.T, tg *testGate) *testClient
+	}{
+		{"tcp", dialTCP},
+		{"ws", dialWS},
+		{"kcp", dialKCP},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tg := startGate(t, func(g *gate.Gate) {
+				g.WSAddr = freeAddr(t)
+				g.WSFraming = network.WSFramingRaw
+				g.KCPAddr = freeUDPAddr(t)
+				g.KCPLenMsgLen = 2
+				g.KCPProcessor = g.Processor
+				g.KCPAgentChanRPC = g.AgentChanRPC
+				g.IdleTimeout = 300 * time.Millisecond
+				g.PingData = []byte("ping")
+				g.PongData = []byte("pong")
+				g.RateLimit = &gate.RateLimit{
+					Msg:    gate.Limit{Rate: 1, Burst: 1},
+					Action: gate.RateLimitDisconnect,
+				}
+			})
+			c := test.dial(t, tg)
+
+			// kcp has no handshake, the agent is new with the first message
+			var last time.Time
+			for i := 0; i < 6; i++ {
+				c.write([]byte("ping"))
+				last = time.Now()
+				if data := c.read(); string(data) != "pong" {
+					t.Fatalf("%q", data)
+				}
+				if i == 0 {
+					tg.agent(t)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			tg.noAgent(t)
+
+			tg.waitClosed(t, gate.CloseTimeout)
+			if d := time.Since(last); d < 250*time.Millisecond {
+				t.Fatalf("closed after %v", d)
+			}
+		})
+	}
+}
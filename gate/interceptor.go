@@ -0,0 +1,41 @@
+package gate
+
+//InboundHandler handle a message read from an agent
+type InboundHandler func(a Agent, msg interface{}) error
+
+//InboundInterceptor desc:
+// called with every unmarshaled message before it is routed
+// call next to pass the message on, return nil without calling next to drop
+// the message, return an error to close the agent
+type InboundInterceptor func(a Agent, msg interface{}, next InboundHandler) error
+
+//OutboundHandler handle a message written to an agent
+type OutboundHandler func(a Agent, msg interface{}) error
+
+//OutboundInterceptor desc:
+// called with every message passed to Agent.WriteMsg before it is marshaled
+// call next to pass the message on, return nil without calling next to drop
+// the message, an error is logged and the message is dropped
+type OutboundInterceptor func(a Agent, msg interface{}, next OutboundHandler) error
+
+// the first interceptor is the outermost
+func chainInbound(interceptors []InboundInterceptor, h InboundHandler) InboundHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(a Agent, msg interface{}) error {
+			return interceptor(a, msg, next)
+		}
+	}
+	return h
+}
+
+// the first interceptor is the outermost
+func chainOutbound(interceptors []OutboundInterceptor, h OutboundHandler) OutboundHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(a Agent, msg interface{}) error {
+			return interceptor(a, msg, next)
+		}
+	}
+	return h
+}
@@ -0,0 +1,120 @@
+package gate_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/gate"
+	leafjson "github.com/somethinghero/leaf/network/json"
+)
+
+func TestInterceptors(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+	call := func(name string, msg interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = append(calls, fmt.Sprintf("%v %v", name, msg.(*Echo).N))
+	}
+
+	routed := make(chan int, 10)
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Processor.(*leafjson.Processor).SetHandler(&Echo{}, func(args []interface{}) {
+			routed <- args[0].(*Echo).N
+		})
+		g.Inbound = []gate.InboundInterceptor{
+			func(a gate.Agent, msg interface{}, next gate.InboundHandler) error {
+				call("in1", msg)
+				if msg.(*Echo).N == 2 {
+					return nil
+				}
+				return next(a, msg)
+			},
+			func(a gate.Agent, msg interface{}, next gate.InboundHandler) error {
+				call("in2", msg)
+				switch msg.(*Echo).N {
+				case 3:
+					return errors.New("rejected")
+				case 4:
+					return next(a, &Echo{N: 104})
+				}
+				return next(a, msg)
+			},
+			func(a gate.Agent, msg interface{}, next gate.InboundHandler) error {
+				call("in3", msg)
+				return next(a, msg)
+			},
+		}
+		g.Outbound = []gate.OutboundInterceptor{
+			func(a gate.Agent, msg interface{}, next gate.OutboundHandler) error {
+				call("out1", msg)
+				return next(a, &Echo{N: msg.(*Echo).N * 10})
+			},
+			func(a gate.Agent, msg interface{}, next gate.OutboundHandler) error {
+				call("out2", msg)
+				if msg.(*Echo).N == 50 {
+					return nil
+				}
+				return next(a, msg)
+			},
+		}
+	})
+
+	conn := dial(t, tg.TCPAddr)
+	a := tg.agent(t)
+	waitRouted := func(want int) {
+		select {
+		case n := <-routed:
+			if n != want {
+				t.Fatalf("routed %v, want %v", n, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v not routed", want)
+		}
+	}
+
+	// 2 is dropped by in1, 4 is rewritten by in2
+	writeFrame(t, conn, []byte(`{"Echo": {"N": 1}}`))
+	waitRouted(1)
+	writeFrame(t, conn, []byte(`{"Echo": {"N": 2}}`))
+	writeFrame(t, conn, []byte(`{"Echo": {"N": 4}}`))
+	waitRouted(104)
+
+	// 5 is rewritten to 50 by out1 and dropped by out2
+	for _, n := range []int{1, 5, 2} {
+		a.WriteMsg(&Echo{N: n})
+	}
+	for _, want := range []int{10, 20} {
+		if n := readPlainEcho(t, conn); n != want {
+			t.Fatalf("echo %v, want %v", n, want)
+		}
+	}
+
+	// 3 is rejected by in2, the agent is closed
+	writeFrame(t, conn, []byte(`{"Echo": {"N": 3}}`))
+	tg.waitClosed(t, gate.CloseProtocol)
+	select {
+	case n := <-routed:
+		t.Fatalf("routed %v", n)
+	default:
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []string{
+		"in1 1", "in2 1", "in3 1",
+		"in1 2",
+		"in1 4", "in2 4", "in3 104",
+		"out1 1", "out2 10",
+		"out1 5", "out2 50",
+		"out1 2", "out2 20",
+		"in1 3", "in2 3",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("%v, want %v", calls, want)
+	}
+}
@@ -0,0 +1,225 @@
+package gate
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/somethinghero/leaf/network"
+)
+
+//RateLimitAction what to do with a message over the limit
+type RateLimitAction int
+
+// actions
+const (
+	// discard the message
+	RateLimitDrop RateLimitAction = iota
+	// stop reading from the agent until the message is within the limit
+	RateLimitDelay
+	// close the agent
+	RateLimitDisconnect
+)
+
+func (action RateLimitAction) String() string {
+	switch action {
+	case RateLimitDrop:
+		return "drop"
+	case RateLimitDelay:
+		return "delay"
+	case RateLimitDisconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+//Limit a token bucket, Rate per second, 0 means no limit
+type Limit struct {
+	Rate float64
+	// default to Rate
+	Burst int
+}
+
+//RateLimit desc:
+// limits of every agent, heartbeats (Gate.PingData) are not counted
+// on violation, "RateLimited" of the agent chanrpc server is called with
+// the agent and the RateLimitAction, at most once a second for an agent
+type RateLimit struct {
+	// messages per second
+	Msg Limit
+	// bytes per second
+	Bytes Limit
+	// messages per second of message types, the key is the type of
+	// unmarshaled messages, e.g. reflect.TypeOf(&msg.Chat{})
+	MsgType map[reflect.Type]Limit
+	// messages per second of raw messages (network.RawMsg), the key is
+	// the registered name, raw messages are not limited by MsgType
+	MsgName map[string]Limit
+	Action  RateLimitAction
+	// RateLimitDelay: the agent is closed if it has to wait longer, default 1s
+	MaxDelay time.Duration
+}
+
+var errRateLimited = errors.New("rate limited")
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// nil if there is no limit
+func newTokenBucket(l Limit, now time.Time) *tokenBucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+	b := new(tokenBucket)
+	b.rate = l.Rate
+	b.burst = float64(l.Burst)
+	if b.burst <= 0 {
+		b.burst = l.Rate
+	}
+	b.tokens = b.burst
+	b.last = now
+	return b
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// n larger than burst is allowed with a full bucket
+func (b *tokenBucket) allow(now time.Time, n float64) bool {
+	b.refill(now)
+	return b.tokens >= n || b.tokens >= b.burst
+}
+
+// take n tokens, return the time to wait until the tokens are paid off
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// used by the read loop of an agent only
+type rateLimiter struct {
+	config     *RateLimit
+	msg        *tokenBucket
+	bytes      *tokenBucket
+	msgType    map[reflect.Type]*tokenBucket
+	msgName    map[string]*tokenBucket
+	lastNotify time.Time
+}
+
+// nil if there is no limit
+func newRateLimiter(config *RateLimit) *rateLimiter {
+	if config == nil {
+		return nil
+	}
+	now := time.Now()
+	l := new(rateLimiter)
+	l.config = config
+	l.msg = newTokenBucket(config.Msg, now)
+	l.bytes = newTokenBucket(config.Bytes, now)
+	for t, limit := range config.MsgType {
+		if b := newTokenBucket(limit, now); b != nil {
+			if l.msgType == nil {
+				l.msgType = make(map[reflect.Type]*tokenBucket)
+			}
+			l.msgType[t] = b
+		}
+	}
+	for name, limit := range config.MsgName {
+		if b := newTokenBucket(limit, now); b != nil {
+			if l.msgName == nil {
+				l.msgName = make(map[string]*tokenBucket)
+			}
+			l.msgName[name] = b
+		}
+	}
+	return l
+}
+
+// limited is true if the message is over the limit,
+// errRateLimited means the agent should be closed
+func (l *rateLimiter) take(buckets []*tokenBucket, n []float64) (limited bool, err error) {
+	now := time.Now()
+	if l.config.Action == RateLimitDelay {
+		var wait time.Duration
+		for i, b := range buckets {
+			if b == nil {
+				continue
+			}
+			if d := b.reserve(now, n[i]); d > wait {
+				wait = d
+			}
+		}
+		if wait == 0 {
+			return false, nil
+		}
+		maxDelay := l.config.MaxDelay
+		if maxDelay <= 0 {
+			maxDelay = time.Second
+		}
+		if wait > maxDelay {
+			return true, errRateLimited
+		}
+		time.Sleep(wait)
+		return true, nil
+	}
+
+	for i, b := range buckets {
+		if b != nil && !b.allow(now, n[i]) {
+			if l.config.Action == RateLimitDisconnect {
+				return true, errRateLimited
+			}
+			return true, nil
+		}
+	}
+	for i, b := range buckets {
+		if b != nil {
+			b.reserve(now, n[i])
+		}
+	}
+	return false, nil
+}
+
+// called with every message read
+func (l *rateLimiter) takeData(data []byte) (bool, error) {
+	if l.msg == nil && l.bytes == nil {
+		return false, nil
+	}
+	return l.take([]*tokenBucket{l.msg, l.bytes}, []float64{1, float64(len(data))})
+}
+
+// called with every message unmarshaled
+func (l *rateLimiter) takeMsg(msg interface{}) (bool, error) {
+	var b *tokenBucket
+	if raw, ok := msg.(network.RawMsg); ok {
+		b = l.msgName[raw.MsgName()]
+	} else {
+		b = l.msgType[reflect.TypeOf(msg)]
+	}
+	if b == nil {
+		return false, nil
+	}
+	return l.take([]*tokenBucket{b}, []float64{1})
+}
+
+// whether to call "RateLimited", at most once a second unless disconnecting
+func (l *rateLimiter) notify(err error) bool {
+	now := time.Now()
+	if err == nil && now.Sub(l.lastNotify) < time.Second {
+		return false
+	}
+	l.lastNotify = now
+	return true
+}
@@ -0,0 +1,236 @@
+package gate_test
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/gate"
+	leafjson "github.com/somethinghero/leaf/network/json"
+)
+
+// routed through a raw handler
+type Raw struct {
+	N int
+}
+
+// the gate routes Echo to routed as "Echo N" and Raw as "Raw {"N":N}"
+func startLimitGate(t *testing.T, limit *gate.RateLimit) (*testGate, chan string) {
+	routed := make(chan string, 100)
+	tg := startGate(t, func(g *gate.Gate) {
+		processor := g.Processor.(*leafjson.Processor)
+		processor.Register(&Raw{})
+		processor.SetHandler(&Echo{}, func(args []interface{}) {
+			routed <- fmt.Sprintf("Echo %v", args[0].(*Echo).N)
+		})
+		processor.SetRawHandler("Raw", func(args []interface{}) {
+			routed <- fmt.Sprintf("Raw %s", args[1])
+		})
+		g.RateLimit = limit
+	})
+	return tg, routed
+}
+
+// | len | {"Echo":{"N":n}} |, 16 bytes of data for a digit
+func writeMsg(t *testing.T, conn net.Conn, name string, n int) {
+	writeFrame(t, conn, []byte(fmt.Sprintf(`{"%v":{"N":%v}}`, name, n)))
+}
+
+// the messages routed until none is for 200ms
+func readRouted(routed chan string) []string {
+	var msgs []string
+	for {
+		select {
+		case msg := <-routed:
+			msgs = append(msgs, msg)
+		case <-time.After(200 * time.Millisecond):
+			return msgs
+		}
+	}
+}
+
+func (tg *testGate) waitLimited(t *testing.T, want gate.RateLimitAction) {
+	select {
+	case action := <-tg.limited:
+		if action != want {
+			t.Fatalf("action %v, want %v", action, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RateLimited is not called")
+	}
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit gate.RateLimit
+		// messages written, "Echo 1" is written as {"Echo":{"N":1}}
+		write  []string
+		routed []string
+	}{
+		{
+			"msg burst",
+			gate.RateLimit{Msg: gate.Limit{Rate: 1, Burst: 3}},
+			[]string{"Echo 1", "Echo 2", "Echo 3", "Raw 4", "Echo 5"},
+			[]string{"Echo 1", "Echo 2", "Echo 3"},
+		},
+		{
+			// 16 bytes a message
+			"bytes",
+			gate.RateLimit{Bytes: gate.Limit{Rate: 40}},
+			[]string{"Echo 1", "Echo 2", "Echo 3"},
+			[]string{"Echo 1", "Echo 2"},
+		},
+		{
+			"msg type",
+			gate.RateLimit{MsgType: map[reflect.Type]gate.Limit{
+				reflect.TypeOf(&Echo{}): {Rate: 1, Burst: 2},
+			}},
+			[]string{"Echo 1", "Echo 2", "Echo 3", "Raw 4", "Raw 5"},
+			[]string{"Echo 1", "Echo 2", `Raw {"N":4}`, `Raw {"N":5}`},
+		},
+		{
+			"msg name",
+			gate.RateLimit{MsgName: map[string]gate.Limit{
+				"Raw": {Rate: 1, Burst: 1},
+			}},
+			[]string{"Raw 1", "Raw 2", "Echo 3", "Echo 4"},
+			[]string{`Raw {"N":1}`, "Echo 3", "Echo 4"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limit := test.limit
+			tg, routed := startLimitGate(t, &limit)
+			conn := dial(t, tg.TCPAddr)
+			tg.agent(t)
+
+			for _, msg := range test.write {
+				var name string
+				var n int
+				fmt.Sscan(msg, &name, &n)
+				writeMsg(t, conn, name, n)
+			}
+			if msgs := readRouted(routed); !reflect.DeepEqual(msgs, test.routed) {
+				t.Fatalf("routed %q, want %q", msgs, test.routed)
+			}
+
+			// once a second for all the messages dropped
+			tg.waitLimited(t, gate.RateLimitDrop)
+			select {
+			case action := <-tg.limited:
+				t.Fatalf("RateLimited %v again", action)
+			default:
+			}
+			tg.noAgent(t)
+		})
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+	tg, routed := startLimitGate(t, &gate.RateLimit{Msg: gate.Limit{Rate: 5, Burst: 3}})
+	conn := dial(t, tg.TCPAddr)
+	tg.agent(t)
+
+	for n := 1; n <= 5; n++ {
+		writeMsg(t, conn, "Echo", n)
+	}
+	want := []string{"Echo 1", "Echo 2", "Echo 3"}
+	if msgs := readRouted(routed); !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("routed %q, want %q", msgs, want)
+	}
+
+	// about 2 tokens in 450ms, readRouted took 200ms of them
+	time.Sleep(250 * time.Millisecond)
+	for n := 6; n <= 8; n++ {
+		writeMsg(t, conn, "Echo", n)
+	}
+	want = []string{"Echo 6", "Echo 7"}
+	if msgs := readRouted(routed); !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("routed %q, want %q", msgs, want)
+	}
+
+	// never more than the burst
+	time.Sleep(time.Second)
+	for n := 9; n <= 13; n++ {
+		writeMsg(t, conn, "Echo", n)
+	}
+	want = []string{"Echo 9", "Echo 10", "Echo 11"}
+	if msgs := readRouted(routed); !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("routed %q, want %q", msgs, want)
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	tg, routed := startLimitGate(t, &gate.RateLimit{
+		Msg:      gate.Limit{Rate: 10, Burst: 1},
+		Action:   gate.RateLimitDelay,
+		MaxDelay: 500 * time.Millisecond,
+	})
+	conn := dial(t, tg.TCPAddr)
+	tg.agent(t)
+
+	// every message is routed, 100ms apart
+	begin := time.Now()
+	for n := 1; n <= 4; n++ {
+		writeMsg(t, conn, "Echo", n)
+	}
+	for n := 1; n <= 4; n++ {
+		select {
+		case msg := <-routed:
+			if msg != fmt.Sprintf("Echo %v", n) {
+				t.Fatalf("routed %q", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Echo %v not routed", n)
+		}
+	}
+	if d := time.Since(begin); d < 250*time.Millisecond {
+		t.Fatalf("routed in %v", d)
+	}
+	tg.waitLimited(t, gate.RateLimitDelay)
+	tg.noAgent(t)
+}
+
+func TestRateLimitMaxDelay(t *testing.T) {
+	// a message of 16 bytes a second
+	tg, routed := startLimitGate(t, &gate.RateLimit{
+		Bytes:    gate.Limit{Rate: 16},
+		Action:   gate.RateLimitDelay,
+		MaxDelay: 500 * time.Millisecond,
+	})
+	conn := dial(t, tg.TCPAddr)
+	tg.agent(t)
+
+	// the second message has to wait for 1s
+	writeMsg(t, conn, "Echo", 1)
+	writeMsg(t, conn, "Echo", 2)
+	tg.waitClosed(t, gate.CloseRateLimited)
+	tg.waitLimited(t, gate.RateLimitDelay)
+	want := []string{"Echo 1"}
+	if msgs := readRouted(routed); !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("routed %q, want %q", msgs, want)
+	}
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	tg, routed := startLimitGate(t, &gate.RateLimit{
+		Msg:    gate.Limit{Rate: 1, Burst: 2},
+		Action: gate.RateLimitDisconnect,
+	})
+	conn := dial(t, tg.TCPAddr)
+	tg.agent(t)
+
+	for n := 1; n <= 4; n++ {
+		writeMsg(t, conn, "Echo", n)
+	}
+	tg.waitClosed(t, gate.CloseRateLimited)
+	tg.waitLimited(t, gate.RateLimitDisconnect)
+	want := []string{"Echo 1", "Echo 2"}
+	if msgs := readRouted(routed); !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("routed %q, want %q", msgs, want)
+	}
+}
@@ -0,0 +1,101 @@
+package gate
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/somethinghero/leaf/network"
+)
+
+//CloseReason why an agent is closed, passed to CloseAgent after the agent
+type CloseReason int32
+
+// reasons
+const (
+	CloseUnknown CloseReason = iota
+	// no message read for Gate.IdleTimeout
+	CloseTimeout
+	// the connection is closed by the client
+	CloseRemote
+	// a message can not be read, unmarshaled or routed,
+	// or an inbound interceptor returns an error
+	CloseProtocol
+	// too many pending writes, see Gate.PendingWriteNum
+	CloseWriteChannelFull
+	// Agent.Close or Agent.Destroy is called
+	CloseKicked
+	// RateLimitDisconnect
+	CloseRateLimited
+	// the gate is stopped
+	CloseShutdown
+	// the user id is bound to another agent, see LoginKickOld
+	CloseDuplicateLogin
+)
+
+func (reason CloseReason) String() string {
+	switch reason {
+	case CloseUnknown:
+		return "unknown"
+	case CloseTimeout:
+		return "timeout"
+	case CloseRemote:
+		return "remote"
+	case CloseProtocol:
+		return "protocol error"
+	case CloseWriteChannelFull:
+		return "write channel full"
+	case CloseKicked:
+		return "kicked"
+	case CloseRateLimited:
+		return "rate limited"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseDuplicateLogin:
+		return "duplicate login"
+	}
+	return "unknown"
+}
+
+// the reason of a read error
+func readErrReason(conn network.Conn, err error) CloseReason {
+	// closed by the network layer
+	if c, ok := conn.(interface{ CloseErr() error }); ok {
+		switch c.CloseErr() {
+		case network.ErrWriteChannelFull:
+			return CloseWriteChannelFull
+		case network.ErrShutdown:
+			return CloseShutdown
+		}
+	}
+
+	switch {
+	case network.IsTimeout(err):
+		return CloseTimeout
+	case errors.Is(err, network.ErrMsgTooLong), errors.Is(err, network.ErrMsgTooShort):
+		return CloseProtocol
+	}
+	return CloseRemote
+}
+
+// the reason of the read loop of conn, ignored if conn is replaced by a
+// resumed one
+func (a *agent) setConnCloseReason(conn network.Conn, reason CloseReason) {
+	if a.session != nil {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		if a.conn != conn {
+			return
+		}
+	}
+	a.setCloseReason(reason)
+}
+
+// the first reason is kept
+func (a *agent) setCloseReason(reason CloseReason) {
+	atomic.CompareAndSwapInt32((*int32)(&a.closeReason), int32(CloseUnknown), int32(reason))
+}
+
+//CloseReason goroutine safe
+func (a *agent) CloseReason() CloseReason {
+	return CloseReason(atomic.LoadInt32((*int32)(&a.closeReason)))
+}
@@ -0,0 +1,119 @@
+package gate_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/gate"
+)
+
+func TestCloseReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(g *gate.Gate)
+		// close the agent a of the client conn
+		close func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent)
+		want  gate.CloseReason
+	}{
+		{"remote", nil, func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent) {
+			conn.Close()
+		}, gate.CloseRemote},
+		{"timeout", func(g *gate.Gate) {
+			g.IdleTimeout = 100 * time.Millisecond
+		}, func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent) {}, gate.CloseTimeout},
+		{"protocol", nil, func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent) {
+			writeFrame(t, conn, []byte("{"))
+		}, gate.CloseProtocol},
+		{"close", nil, func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent) {
+			a.Close()
+		}, gate.CloseKicked},
+		{"destroy", nil, func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent) {
+			a.Destroy()
+		}, gate.CloseKicked},
+		{"shutdown", nil, func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent) {
+			tg.closeSig <- true
+		}, gate.CloseShutdown},
+		{"duplicate login", nil, func(t *testing.T, tg *testGate, conn net.Conn, a gate.Agent) {
+			if err := tg.Bind("u1", a); err != nil {
+				t.Fatal(err)
+			}
+			dial(t, tg.TCPAddr)
+			if err := tg.Bind("u1", tg.agent(t)); err != nil {
+				t.Fatal(err)
+			}
+		}, gate.CloseDuplicateLogin},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tg := startGate(t, test.config)
+			conn := dial(t, tg.TCPAddr)
+			a := tg.agent(t)
+			if reason := a.CloseReason(); reason != gate.CloseUnknown {
+				t.Fatalf("open agent: %v", reason)
+			}
+
+			test.close(t, tg, conn, a)
+			tg.waitClosed(t, test.want)
+			if reason := a.CloseReason(); reason != test.want {
+				t.Fatalf("CloseReason %v, want %v", reason, test.want)
+			}
+		})
+	}
+}
+
+func TestAgentUserID(t *testing.T) {
+	tg := startGate(t, nil)
+	dial(t, tg.TCPAddr)
+	a := tg.agent(t)
+
+	if uid := a.UserID(); uid != nil {
+		t.Fatalf("%v", uid)
+	}
+	if err := tg.Bind(1001, a); err != nil {
+		t.Fatal(err)
+	}
+	if uid := a.UserID(); uid != 1001 {
+		t.Fatalf("%v", uid)
+	}
+	tg.Unbind(a)
+	if uid := a.UserID(); uid != nil {
+		t.Fatalf("%v", uid)
+	}
+}
+
+func TestAgentLastAcked(t *testing.T) {
+	tg := startGate(t, nil)
+	dial(t, tg.TCPAddr)
+	if acked := tg.agent(t).LastAcked(); acked != 0 {
+		t.Fatalf("LastAcked %v without SeqAck", acked)
+	}
+
+	tg = startGate(t, func(g *gate.Gate) {
+		g.SeqAck = true
+	})
+	conn := dial(t, tg.TCPAddr)
+	a := tg.agent(t)
+	// | seq | ack | message |
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, 1)
+	binary.BigEndian.PutUint32(header[4:], 7)
+	writeFrame(t, conn, header, []byte(`{"Echo":{"N":1}}`))
+	waitAcked(t, a, 7)
+}
+
+func waitAcked(t *testing.T, a gate.Agent, want uint32) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		acked := a.LastAcked()
+		if acked == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("LastAcked %v, want %v", acked, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+package gate
+
+import (
+	"errors"
+)
+
+//LoginPolicy what to do when a user id already bound is bound to another agent
+type LoginPolicy int
+
+// policies
+const (
+	// close the agents bound before with CloseDuplicateLogin
+	LoginKickOld LoginPolicy = iota
+	// Bind returns ErrDuplicateLogin
+	LoginRejectNew
+	// a user id is bound to many agents
+	LoginAllowMultiple
+)
+
+func (policy LoginPolicy) String() string {
+	switch policy {
+	case LoginKickOld:
+		return "kick old"
+	case LoginRejectNew:
+		return "reject new"
+	case LoginAllowMultiple:
+		return "allow multiple"
+	}
+	return "unknown"
+}
+
+// errors of Bind
+var (
+	ErrDuplicateLogin = errors.New("duplicate login")
+	ErrAgentClosed    = errors.New("agent closed")
+)
+
+//Bind desc:
+// bind an authenticated user id to the agent, uid must be comparable
+// the agent is unbound when it is closed, by Gate.LoginPolicy if uid is
+// already bound
+// goroutine safe
+func (gate *Gate) Bind(uid interface{}, a Agent) error {
+	ag, ok := a.(*agent)
+	if !ok {
+		return errors.New("not an agent of gate")
+	}
+
+	gate.registryMutex.Lock()
+	ag.mutex.Lock()
+	closed := ag.closed
+	ag.mutex.Unlock()
+	if closed {
+		gate.registryMutex.Unlock()
+		return ErrAgentClosed
+	}
+	if ag.bound {
+		if ag.uid == uid {
+			gate.registryMutex.Unlock()
+			return nil
+		}
+		gate.unbindLocked(ag)
+	}
+
+	var kicked []*agent
+	if others := gate.registry[uid]; len(others) > 0 {
+		switch gate.LoginPolicy {
+		case LoginRejectNew:
+			gate.registryMutex.Unlock()
+			return ErrDuplicateLogin
+		case LoginKickOld:
+			kicked = others
+			for _, other := range others {
+				other.uid = nil
+				other.bound = false
+			}
+			delete(gate.registry, uid)
+		}
+	}
+	if gate.registry == nil {
+		gate.registry = make(map[interface{}][]*agent)
+	}
+	gate.registry[uid] = append(gate.registry[uid], ag)
+	ag.uid = uid
+	ag.bound = true
+	gate.registryMutex.Unlock()
+
+	for _, other := range kicked {
+		other.closeWith(CloseDuplicateLogin)
+	}
+	return nil
+}
+
+//Unbind goroutine safe
+func (gate *Gate) Unbind(a Agent) {
+	ag, ok := a.(*agent)
+	if !ok {
+		return
+	}
+
+	gate.registryMutex.Lock()
+	defer gate.registryMutex.Unlock()
+	if ag.bound {
+		gate.unbindLocked(ag)
+	}
+}
+
+func (gate *Gate) unbindLocked(a *agent) {
+	agents := gate.registry[a.uid]
+	for i, other := range agents {
+		if other == a {
+			agents = append(agents[:i:i], agents[i+1:]...)
+			break
+		}
+	}
+	if len(agents) == 0 {
+		delete(gate.registry, a.uid)
+	} else {
+		gate.registry[a.uid] = agents
+	}
+	a.uid = nil
+	a.bound = false
+}
+
+//GetAgent the agent bound to uid last, nil if none
+// goroutine safe
+func (gate *Gate) GetAgent(uid interface{}) Agent {
+	gate.registryMutex.Lock()
+	defer gate.registryMutex.Unlock()
+	agents := gate.registry[uid]
+	if len(agents) == 0 {
+		return nil
+	}
+	return agents[len(agents)-1]
+}
+
+//GetAgents the agents bound to uid, in order of binding
+// goroutine safe
+func (gate *Gate) GetAgents(uid interface{}) []Agent {
+	gate.registryMutex.Lock()
+	defer gate.registryMutex.Unlock()
+	agents := make([]Agent, 0, len(gate.registry[uid]))
+	for _, a := range gate.registry[uid] {
+		agents = append(agents, a)
+	}
+	return agents
+}
+
+//UserID nil if the agent is not bound
+// goroutine safe
+func (a *agent) UserID() interface{} {
+	a.gate.registryMutex.Lock()
+	defer a.gate.registryMutex.Unlock()
+	return a.uid
+}
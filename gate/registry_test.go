@@ -0,0 +1,135 @@
+package gate_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/gate"
+)
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		policy gate.LoginPolicy
+		err    error
+		// the agents bound after the second Bind, 0 is the first agent
+		bound []int
+		// the first agent is kicked
+		kicked bool
+	}{
+		{gate.LoginKickOld, nil, []int{1}, true},
+		{gate.LoginRejectNew, gate.ErrDuplicateLogin, []int{0}, false},
+		{gate.LoginAllowMultiple, nil, []int{0, 1}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			tg := startGate(t, func(g *gate.Gate) {
+				g.LoginPolicy = test.policy
+			})
+			var agents []gate.Agent
+			for i := 0; i < 2; i++ {
+				dial(t, tg.TCPAddr)
+				agents = append(agents, tg.agent(t))
+			}
+
+			if err := tg.Bind("u1", agents[0]); err != nil {
+				t.Fatal(err)
+			}
+			// bound again
+			if err := tg.Bind("u1", agents[0]); err != nil {
+				t.Fatal(err)
+			}
+			if err := tg.Bind("u1", agents[1]); err != test.err {
+				t.Fatalf("%v, want %v", err, test.err)
+			}
+
+			var want []gate.Agent
+			for _, i := range test.bound {
+				want = append(want, agents[i])
+			}
+			if got := tg.GetAgents("u1"); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%v, want %v", got, want)
+			}
+			if a := tg.GetAgent("u1"); a != want[len(want)-1] {
+				t.Fatalf("GetAgent %v", a)
+			}
+			for i, a := range agents {
+				bound := false
+				for _, b := range test.bound {
+					bound = bound || b == i
+				}
+				if uid := a.UserID(); (uid == "u1") != bound {
+					t.Fatalf("agent %v: UserID %v", i, uid)
+				}
+			}
+
+			if test.kicked {
+				tg.waitClosed(t, gate.CloseDuplicateLogin)
+			}
+			tg.noAgent(t)
+		})
+	}
+}
+
+// an agent is unbound when it binds another user id or is closed
+func TestUnbind(t *testing.T) {
+	tg := startGate(t, nil)
+	conn := dial(t, tg.TCPAddr)
+	a := tg.agent(t)
+
+	if err := tg.Bind("u1", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := tg.Bind("u2", a); err != nil {
+		t.Fatal(err)
+	}
+	if tg.GetAgent("u1") != nil || tg.GetAgent("u2") != a {
+		t.Fatalf("%v %v", tg.GetAgent("u1"), tg.GetAgent("u2"))
+	}
+
+	conn.Close()
+	tg.waitClosed(t, gate.CloseRemote)
+	if b := tg.GetAgent("u2"); b != nil {
+		t.Fatalf("closed agent bound: %v", b)
+	}
+	if uid := a.UserID(); uid != nil {
+		t.Fatalf("%v", uid)
+	}
+	if err := tg.Bind("u2", a); err != gate.ErrAgentClosed {
+		t.Fatalf("%v", err)
+	}
+}
+
+// a session waiting for its client is closed with CloseDuplicateLogin
+// instead of the reason of its lost connection
+func TestBindKickDetached(t *testing.T) {
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Session = &gate.Session{GracePeriod: 5 * time.Second}
+	})
+
+	conn := dial(t, tg.TCPAddr)
+	hello(t, conn)
+	old := tg.agent(t)
+	if err := tg.Bind("u1", old); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for old.CloseReason() != gate.CloseRemote {
+		if time.Now().After(deadline) {
+			t.Fatalf("the session is not detached: %v", old.CloseReason())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	tg.noAgent(t)
+
+	hello(t, dial(t, tg.TCPAddr))
+	if err := tg.Bind("u1", tg.agent(t)); err != nil {
+		t.Fatal(err)
+	}
+	tg.waitClosed(t, gate.CloseDuplicateLogin)
+	if reason := old.CloseReason(); reason != gate.CloseDuplicateLogin {
+		t.Fatalf("%v", reason)
+	}
+}
@@ -0,0 +1,69 @@
+package gate
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+)
+
+// with Gate.SeqAck, every message has a header
+// (after the frame type in session mode):
+// -------------------------------------------
+// | seq(4 bytes) | ack(4 bytes) | message |
+// -------------------------------------------
+// seq numbers the messages of a side from 1, 0 for heartbeats
+// ack is the seq of the last message received from the other side
+// a message with seq not larger than the last one received is a duplicate
+// and dropped
+const seqAckLen = 8
+
+var errSeqAckTooShort = errors.New("seq ack header too short")
+
+func (a *agent) byteOrder() binary.ByteOrder {
+	if a.littleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// the frame of a message written with seq, 0 for heartbeats
+func (a *agent) frame(seq uint32, data ...[]byte) [][]byte {
+	frame := make([][]byte, 0, len(data)+2)
+	if a.session != nil {
+		frame = append(frame, []byte{frameData})
+	}
+	if a.gate.SeqAck {
+		header := make([]byte, seqAckLen)
+		a.byteOrder().PutUint32(header, seq)
+		a.byteOrder().PutUint32(header[4:], atomic.LoadUint32(&a.received))
+		frame = append(frame, header)
+	}
+	return append(frame, data...)
+}
+
+// returns the message, dup is true if the message is a duplicate
+func (a *agent) readSeqAck(data []byte) (msg []byte, dup bool, err error) {
+	if len(data) < seqAckLen {
+		return nil, false, errSeqAckTooShort
+	}
+	seq := a.byteOrder().Uint32(data)
+	ack := a.byteOrder().Uint32(data[4:])
+	for {
+		acked := atomic.LoadUint32(&a.acked)
+		if ack <= acked || atomic.CompareAndSwapUint32(&a.acked, acked, ack) {
+			break
+		}
+	}
+	if seq != 0 {
+		if seq <= atomic.LoadUint32(&a.received) {
+			return nil, true, nil
+		}
+		atomic.StoreUint32(&a.received, seq)
+	}
+	return data[seqAckLen:], false, nil
+}
+
+//LastAcked goroutine safe
+func (a *agent) LastAcked() uint32 {
+	return atomic.LoadUint32(&a.acked)
+}
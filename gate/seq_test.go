@@ -0,0 +1,99 @@
+package gate_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/gate"
+	leafjson "github.com/somethinghero/leaf/network/json"
+)
+
+func TestSeqAck(t *testing.T) {
+	for _, littleEndian := range []bool{false, true} {
+		t.Run(fmt.Sprintf("little endian %v", littleEndian), func(t *testing.T) {
+			var order binary.ByteOrder = binary.BigEndian
+			if littleEndian {
+				order = binary.LittleEndian
+			}
+			routed := make(chan string, 100)
+			tg := startGate(t, func(g *gate.Gate) {
+				g.Processor.(*leafjson.Processor).SetHandler(&Echo{}, func(args []interface{}) {
+					routed <- fmt.Sprintf("Echo %v", args[0].(*Echo).N)
+				})
+				g.SeqAck = true
+				g.LittleEndian = littleEndian
+				g.PingData = []byte("ping")
+				g.PongData = []byte("pong")
+			})
+			conn := dial(t, tg.TCPAddr)
+			a := tg.agent(t)
+
+			// | len | seq | ack | message |, len is in the byte order of the gate
+			write := func(seq, ack uint32, msg string) {
+				buf := make([]byte, 2+8+len(msg))
+				order.PutUint16(buf, uint16(8+len(msg)))
+				order.PutUint32(buf[2:], seq)
+				order.PutUint32(buf[6:], ack)
+				copy(buf[10:], msg)
+				if _, err := conn.Write(buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			read := func() (seq, ack uint32, msg string) {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				buf := make([]byte, 2)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					t.Fatal(err)
+				}
+				data := make([]byte, order.Uint16(buf))
+				if _, err := io.ReadFull(conn, data); err != nil {
+					t.Fatal(err)
+				}
+				return order.Uint32(data), order.Uint32(data[4:]), string(data[8:])
+			}
+
+			// duplicate and out of order seqs are dropped, acks never go back
+			for _, m := range []struct {
+				seq, ack uint32
+				n        int
+			}{
+				{1, 0, 1},
+				{2, 3, 2},
+				{2, 3, 102},
+				{1, 2, 101},
+				{4, 5, 4},
+				{3, 4, 103},
+				{5, 4, 5},
+			} {
+				write(m.seq, m.ack, fmt.Sprintf(`{"Echo":{"N":%v}}`, m.n))
+			}
+			want := []string{"Echo 1", "Echo 2", "Echo 4", "Echo 5"}
+			if msgs := readRouted(routed); !reflect.DeepEqual(msgs, want) {
+				t.Fatalf("routed %q, want %q", msgs, want)
+			}
+			if acked := a.LastAcked(); acked != 5 {
+				t.Fatalf("LastAcked %v", acked)
+			}
+
+			// heartbeats have seq 0, are not counted and carry acks
+			write(0, 6, "ping")
+			if seq, ack, msg := read(); seq != 0 || ack != 5 || msg != "pong" {
+				t.Fatalf("%v %v %q", seq, ack, msg)
+			}
+			waitAcked(t, a, 6)
+
+			// the seqs of the gate
+			for n := 1; n <= 3; n++ {
+				a.WriteMsg(&Echo{N: n})
+				seq, ack, msg := read()
+				if seq != uint32(n) || ack != 5 || msg != fmt.Sprintf(`{"Echo":{"N":%v}}`, n) {
+					t.Fatalf("%v %v %q", seq, ack, msg)
+				}
+			}
+		})
+	}
+}
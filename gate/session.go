@@ -0,0 +1,293 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/somethinghero/leaf/chanrpc"
+	"github.com/somethinghero/leaf/log"
+	"github.com/somethinghero/leaf/network"
+)
+
+//Session desc:
+// an agent outlives its connection, a client reconnecting within GracePeriod
+// resumes the session with the same agent and gets the messages it missed
+// the first byte of every message is the frame type:
+// ------------------------------------------------------------------------
+// | frameData    | message                   | both, a message           |
+// | frameHello   |                           | client, start a session   |
+// | frameResume  | received(4 bytes) | token | client, resume a session  |
+// | frameSession | token                     | server, a new session     |
+// | frameResumed |                           | server, missed messages   |
+// |              |                           | are sent after the frame  |
+// ------------------------------------------------------------------------
+// received is the number of data frames the client has received in the
+// session, pongs of the heartbeat are not counted, the same as the seq of the
+// last message received with Gate.SeqAck
+// if a session can not be resumed, a new session is started
+type Session struct {
+	// time a disconnected session is kept, default 1m
+	GracePeriod time.Duration
+	// messages kept for replay, default Gate.PendingWriteNum-1, must be less
+	// than Gate.PendingWriteNum for the replay not to fill the write channel
+	BufferSize int
+}
+
+// frame types
+const (
+	frameData byte = iota
+	frameHello
+	frameResume
+	frameSession
+	frameResumed
+)
+
+var errSessionClosed = errors.New("session closed")
+
+type session struct {
+	token    string
+	attached bool
+	closed   bool
+	// the last messages written, the message of seq n is at (n-1)%len
+	buffer [][][]byte
+	timer  *time.Timer
+	// closed when the read loop of the attached conn exits
+	done chan struct{}
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("session token: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// the network agent of a connection in session mode
+type sessionConn struct {
+	gate         *Gate
+	conn         network.Conn
+	processor    network.Processor
+	rpc          *chanrpc.Server
+	littleEndian bool
+	// the chanrpc called with a new session
+	newAgent string
+	agent    *agent
+}
+
+func (sc *sessionConn) Run() {
+	data, err := sc.conn.ReadMsg()
+	if err != nil {
+		log.Debug("read session handshake: %v", err)
+		return
+	}
+
+	var a *agent
+	var done chan struct{}
+	switch {
+	case len(data) == 1 && data[0] == frameHello:
+	case len(data) > 5 && data[0] == frameResume:
+		var received uint32
+		if sc.littleEndian {
+			received = binary.LittleEndian.Uint32(data[1:])
+		} else {
+			received = binary.BigEndian.Uint32(data[1:])
+		}
+		a, done = sc.gate.resumeSession(sc, string(data[5:]), received)
+	default:
+		log.Debug("invalid session handshake")
+		return
+	}
+	if a == nil {
+		a, done = sc.gate.newSession(sc)
+		if a == nil {
+			return
+		}
+	}
+	sc.agent = a
+	defer close(done)
+	a.serve(sc.conn)
+}
+
+func (sc *sessionConn) OnClose() {
+	if sc.agent != nil {
+		sc.agent.detach(sc.conn)
+	}
+}
+
+func (gate *Gate) newSession(sc *sessionConn) (*agent, chan struct{}) {
+	a := gate.newAgent(sc.conn, sc.processor, sc.rpc, sc.littleEndian)
+	a.session = &session{
+		token:    newToken(),
+		attached: true,
+		buffer:   make([][][]byte, gate.Session.BufferSize),
+		done:     make(chan struct{}),
+	}
+	err := sc.conn.WriteMsg([]byte{frameSession}, []byte(a.session.token))
+	if err != nil {
+		log.Debug("write session: %v", err)
+		return nil, nil
+	}
+
+	gate.sessionMutex.Lock()
+	if gate.sessions == nil {
+		gate.sessions = make(map[string]*agent)
+	}
+	gate.sessions[a.session.token] = a
+	gate.sessionMutex.Unlock()
+
+	if sc.rpc != nil {
+		sc.rpc.Go(sc.newAgent, a)
+	}
+	return a, a.session.done
+}
+
+// nil if the session can not be resumed
+func (gate *Gate) resumeSession(sc *sessionConn, token string, received uint32) (*agent, chan struct{}) {
+	gate.sessionMutex.Lock()
+	a := gate.sessions[token]
+	gate.sessionMutex.Unlock()
+	if a == nil || a.processor != sc.processor {
+		log.Debug("session not found")
+		return nil, nil
+	}
+
+	a.mutex.Lock()
+	s := a.session
+	kept := uint32(len(s.buffer))
+	if a.sent < kept {
+		kept = a.sent
+	}
+	if s.closed || received > a.sent || a.sent-received > kept {
+		a.mutex.Unlock()
+		log.Debug("session can not be resumed")
+		return nil, nil
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	oldConn, oldAttached, oldDone := a.conn, s.attached, s.done
+	a.conn = sc.conn
+	s.attached = true
+	s.done = make(chan struct{})
+	atomic.StoreInt32((*int32)(&a.closeReason), int32(CloseUnknown))
+
+	sc.conn.WriteMsg([]byte{frameResumed})
+	for seq := received + 1; seq != a.sent+1; seq++ {
+		sc.conn.WriteMsg(a.frame(seq, s.buffer[(seq-1)%uint32(len(s.buffer))]...)...)
+	}
+	done := s.done
+	a.mutex.Unlock()
+
+	// the client reconnects before the old connection is found broken,
+	// pending writes to it are discarded for a half-open socket not to block
+	if oldAttached {
+		oldConn.Destroy()
+		<-oldDone
+	}
+	return a, done
+}
+
+// close sessions waiting for clients, called when the gate is stopped
+func (gate *Gate) closeSessions() {
+	gate.sessionMutex.Lock()
+	agents := make([]*agent, 0, len(gate.sessions))
+	for _, a := range gate.sessions {
+		agents = append(agents, a)
+	}
+	gate.sessionMutex.Unlock()
+
+	for _, a := range agents {
+		a.closeSession(false, CloseShutdown)
+	}
+}
+
+func (a *agent) writeSession(data [][]byte) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	s := a.session
+	if s.closed {
+		return errSessionClosed
+	}
+	a.sent++
+	s.buffer[(a.sent-1)%uint32(len(s.buffer))] = data
+	if !s.attached {
+		return nil
+	}
+	return a.conn.WriteMsg(a.frame(a.sent, data...)...)
+}
+
+// called when the read loop of conn exits
+func (a *agent) detach(conn network.Conn) {
+	a.mutex.Lock()
+	s := a.session
+	if a.conn != conn || !s.attached {
+		// replaced by a resumed connection
+		a.mutex.Unlock()
+		return
+	}
+	s.attached = false
+	reason := a.CloseReason()
+	if !s.closed && (reason == CloseRemote || reason == CloseTimeout) {
+		s.timer = time.AfterFunc(a.gate.Session.GracePeriod, a.expire)
+		a.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	a.mutex.Unlock()
+	a.endSession()
+}
+
+func (a *agent) expire() {
+	a.mutex.Lock()
+	s := a.session
+	if s.attached || s.closed {
+		a.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	a.mutex.Unlock()
+	a.endSession()
+}
+
+// Agent.Close and Agent.Destroy in session mode, reason replaces the reason
+// of the lost connection of a session waiting for its client
+func (a *agent) closeSession(destroy bool, reason CloseReason) {
+	a.mutex.Lock()
+	s := a.session
+	conn, attached, closing := a.conn, s.attached, !s.closed
+	if closing && !attached {
+		atomic.StoreInt32((*int32)(&a.closeReason), int32(reason))
+	} else {
+		a.setCloseReason(reason)
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	a.mutex.Unlock()
+
+	if attached {
+		// the session is ended by detach
+		if destroy {
+			conn.Destroy()
+		} else {
+			conn.Close()
+		}
+	} else if closing {
+		a.endSession()
+	}
+}
+
+func (a *agent) endSession() {
+	a.gate.sessionMutex.Lock()
+	delete(a.gate.sessions, a.session.token)
+	a.gate.sessionMutex.Unlock()
+	a.OnClose()
+}
@@ -0,0 +1,471 @@
+package gate_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/somethinghero/leaf/chanrpc"
+	"github.com/somethinghero/leaf/gate"
+	"github.com/somethinghero/leaf/network"
+	leafjson "github.com/somethinghero/leaf/network/json"
+)
+
+type Echo struct {
+	N int
+}
+
+// frame types of gate.Session
+const (
+	frameData byte = iota
+	frameHello
+	frameResume
+	frameSession
+	frameResumed
+)
+
+type testGate struct {
+	*gate.Gate
+	closeSig chan bool
+	done     chan struct{}
+	agents   chan gate.Agent
+	closed   chan gate.CloseReason
+	limited  chan gate.RateLimitAction
+}
+
+func startGate(t *testing.T, config func(g *gate.Gate)) *testGate {
+	processor := leafjson.NewProcessor()
+	processor.Register(&Echo{})
+
+	tg := &testGate{
+		closeSig: make(chan bool, 1),
+		done:     make(chan struct{}),
+		agents:   make(chan gate.Agent, 10),
+		closed:   make(chan gate.CloseReason, 10),
+		limited:  make(chan gate.RateLimitAction, 10),
+	}
+	rpc := chanrpc.NewServer(10)
+	newAgent := func(args []interface{}) {
+		tg.agents <- args[0].(gate.Agent)
+	}
+	rpc.Register("NewAgent", newAgent)
+	rpc.Register("NewKCPAgent", newAgent)
+	rpc.Register("CloseAgent", func(args []interface{}) {
+		tg.closed <- args[1].(gate.CloseReason)
+	})
+	rpc.Register("RateLimited", func(args []interface{}) {
+		tg.limited <- args[1].(gate.RateLimitAction)
+	})
+	go func() {
+		for ci := range rpc.ChanCall {
+			rpc.Exec(ci)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tg.Gate = &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 10,
+		MaxMsgLen:       4096,
+		Processor:       processor,
+		AgentChanRPC:    rpc,
+		TCPAddr:         addr,
+		LenMsgLen:       2,
+	}
+	if config != nil {
+		config(tg.Gate)
+	}
+	if err := tg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		tg.Run(tg.closeSig)
+		close(tg.done)
+	}()
+	t.Cleanup(func() {
+		tg.closeSig <- true
+		<-tg.done
+	})
+	return tg
+}
+
+func (tg *testGate) agent(t *testing.T) gate.Agent {
+	select {
+	case a := <-tg.agents:
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatal("no new agent")
+	}
+	return nil
+}
+
+func (tg *testGate) noAgent(t *testing.T) {
+	select {
+	case <-tg.agents:
+		t.Fatal("unexpected new agent")
+	case reason := <-tg.closed:
+		t.Fatalf("unexpected closed agent: %v", reason)
+	default:
+	}
+}
+
+func (tg *testGate) waitClosed(t *testing.T, want gate.CloseReason) {
+	select {
+	case reason := <-tg.closed:
+		if reason != want {
+			t.Fatalf("close reason %v, want %v", reason, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the agent is not closed")
+	}
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// | len(2 bytes) | data |
+func writeFrame(t *testing.T, conn net.Conn, data ...[]byte) {
+	msg := bytes.Join(data, nil)
+	buf := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	if _, err := conn.Write(append(buf, msg...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFrame(t *testing.T, conn net.Conn) []byte {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(buf))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func readEcho(t *testing.T, conn net.Conn) int {
+	msg := readFrame(t, conn)
+	if len(msg) == 0 || msg[0] != frameData {
+		t.Fatalf("not a data frame: %q", msg)
+	}
+	var m map[string]Echo
+	if err := json.Unmarshal(msg[1:], &m); err != nil {
+		t.Fatalf("%q: %v", msg, err)
+	}
+	return m["Echo"].N
+}
+
+func hello(t *testing.T, conn net.Conn) string {
+	writeFrame(t, conn, []byte{frameHello})
+	msg := readFrame(t, conn)
+	if len(msg) < 2 || msg[0] != frameSession {
+		t.Fatalf("not a session frame: %q", msg)
+	}
+	return string(msg[1:])
+}
+
+func resume(t *testing.T, conn net.Conn, token string, received uint32) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, received)
+	writeFrame(t, conn, []byte{frameResume}, buf, []byte(token))
+}
+
+func TestSessionResume(t *testing.T) {
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Session = &gate.Session{GracePeriod: 5 * time.Second, BufferSize: 5}
+	})
+
+	conn := dial(t, tg.TCPAddr)
+	token := hello(t, conn)
+	a := tg.agent(t)
+	for n := 1; n <= 3; n++ {
+		a.WriteMsg(&Echo{N: n})
+	}
+	if n := readEcho(t, conn); n != 1 {
+		t.Fatalf("echo %v", n)
+	}
+
+	// messages 2 and 3 are lost with the connection, 4 and 5 are written
+	// while the client is away
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	a.WriteMsg(&Echo{N: 4})
+	a.WriteMsg(&Echo{N: 5})
+
+	conn = dial(t, tg.TCPAddr)
+	resume(t, conn, token, 1)
+	if msg := readFrame(t, conn); len(msg) != 1 || msg[0] != frameResumed {
+		t.Fatalf("not a resumed frame: %q", msg)
+	}
+	for want := 2; want <= 5; want++ {
+		if n := readEcho(t, conn); n != want {
+			t.Fatalf("echo %v, want %v", n, want)
+		}
+	}
+
+	// the same agent
+	a.WriteMsg(&Echo{N: 6})
+	if n := readEcho(t, conn); n != 6 {
+		t.Fatalf("echo %v", n)
+	}
+	tg.noAgent(t)
+
+	// the session is ended by Close
+	a.Close()
+	tg.waitClosed(t, gate.CloseKicked)
+}
+
+// the client resumes before the old connection is found broken
+func TestSessionTakeover(t *testing.T) {
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Session = &gate.Session{GracePeriod: 5 * time.Second}
+	})
+
+	old := dial(t, tg.TCPAddr)
+	token := hello(t, old)
+	a := tg.agent(t)
+	a.WriteMsg(&Echo{N: 1})
+
+	conn := dial(t, tg.TCPAddr)
+	resume(t, conn, token, 0)
+	if msg := readFrame(t, conn); len(msg) != 1 || msg[0] != frameResumed {
+		t.Fatalf("not a resumed frame: %q", msg)
+	}
+	if n := readEcho(t, conn); n != 1 {
+		t.Fatalf("echo %v", n)
+	}
+
+	// the old connection is destroyed
+	old.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, err := old.Read(make([]byte, 64))
+		if err == nil {
+			continue
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("the old connection is not closed")
+		}
+		break
+	}
+	tg.noAgent(t)
+}
+
+// the replay of a full buffer does not fill the write channel
+func TestSessionReplayFull(t *testing.T) {
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Session = &gate.Session{GracePeriod: 5 * time.Second}
+	})
+	if tg.Session.BufferSize != tg.PendingWriteNum-1 {
+		t.Fatalf("BufferSize %v", tg.Session.BufferSize)
+	}
+
+	conn := dial(t, tg.TCPAddr)
+	token := hello(t, conn)
+	a := tg.agent(t)
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	for n := 1; n <= tg.Session.BufferSize; n++ {
+		a.WriteMsg(&Echo{N: n})
+	}
+
+	conn = dial(t, tg.TCPAddr)
+	resume(t, conn, token, 0)
+	if msg := readFrame(t, conn); len(msg) != 1 || msg[0] != frameResumed {
+		t.Fatalf("not a resumed frame: %q", msg)
+	}
+	for want := 1; want <= tg.Session.BufferSize; want++ {
+		if n := readEcho(t, conn); n != want {
+			t.Fatalf("echo %v, want %v", n, want)
+		}
+	}
+	tg.noAgent(t)
+}
+
+// a client missed more messages than BufferSize gets a new session
+func TestSessionReplayOverflow(t *testing.T) {
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Session = &gate.Session{GracePeriod: 5 * time.Second, BufferSize: 3}
+	})
+
+	conn := dial(t, tg.TCPAddr)
+	token := hello(t, conn)
+	a := tg.agent(t)
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	for n := 1; n <= 4; n++ {
+		a.WriteMsg(&Echo{N: n})
+	}
+
+	conn = dial(t, tg.TCPAddr)
+	resume(t, conn, token, 0)
+	msg := readFrame(t, conn)
+	if len(msg) < 2 || msg[0] != frameSession || string(msg[1:]) == token {
+		t.Fatalf("not a new session frame: %q", msg)
+	}
+	if tg.agent(t) == a {
+		t.Fatal("the agent is resumed")
+	}
+}
+
+func TestSessionBufferSize(t *testing.T) {
+	for _, test := range []struct {
+		pendingWriteNum int
+		bufferSize      int
+		ok              bool
+	}{
+		{10, 9, true},
+		{10, 10, false},
+		{10, 256, false},
+		// default to PendingWriteNum-1
+		{10, 0, true},
+		{1, 0, false},
+	} {
+		g := &gate.Gate{
+			PendingWriteNum: test.pendingWriteNum,
+			Session:         &gate.Session{BufferSize: test.bufferSize},
+		}
+		err := g.Start()
+		if (err == nil) != test.ok {
+			t.Fatalf("PendingWriteNum %v, BufferSize %v: %v", test.pendingWriteNum, test.bufferSize, err)
+		}
+		if err == nil && g.Session.BufferSize >= g.PendingWriteNum {
+			t.Fatalf("BufferSize %v", g.Session.BufferSize)
+		}
+	}
+}
+
+// a self-signed certificate of 127.0.0.1
+func newTestCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "leaf.crt")
+	keyFile = filepath.Join(dir, "leaf.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// the old connection is a tls connection over websocket
+func TestSessionTakeoverWSS(t *testing.T) {
+	certFile, keyFile, pool := newTestCert(t)
+	tg := startGate(t, func(g *gate.Gate) {
+		g.Session = &gate.Session{GracePeriod: 5 * time.Second}
+		g.WSAddr = freeAddr(t)
+		g.CertFile = certFile
+		g.KeyFile = keyFile
+		g.WSFraming = network.WSFramingRaw
+	})
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	dialWSS := func() *websocket.Conn {
+		conn, _, err := dialer.Dial("wss://"+tg.WSAddr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	readMsg := func(conn *websocket.Conn) []byte {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	old := dialWSS()
+	old.WriteMessage(websocket.BinaryMessage, []byte{frameHello})
+	msg := readMsg(old)
+	if len(msg) < 2 || msg[0] != frameSession {
+		t.Fatalf("not a session frame: %q", msg)
+	}
+	token := msg[1:]
+	a := tg.agent(t)
+	a.WriteMsg(&Echo{N: 1})
+
+	conn := dialWSS()
+	conn.WriteMessage(websocket.BinaryMessage, append([]byte{frameResume, 0, 0, 0, 0}, token...))
+	if msg := readMsg(conn); len(msg) != 1 || msg[0] != frameResumed {
+		t.Fatalf("not a resumed frame: %q", msg)
+	}
+	if msg := readMsg(conn); string(msg) != "\x00"+`{"Echo":{"N":1}}` {
+		t.Fatalf("%q", msg)
+	}
+
+	// the old connection is destroyed
+	old.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := old.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("the old connection is not closed")
+		}
+		break
+	}
+	tg.noAgent(t)
+}
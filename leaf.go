@@ -1,48 +1,67 @@
 package leaf
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"syscall"
 
-	//"github.com/somethinghero/leaf/cluster"
 	"github.com/somethinghero/leaf/conf"
-	//"github.com/somethinghero/leaf/console"
 	"github.com/somethinghero/leaf/log"
 	"github.com/somethinghero/leaf/module"
 )
 
-//Run run all module
-func Run(mods ...module.Module) {
+//Run desc:
+// run an App with all modules until the process is signaled
+// see App.Start and App.Stop for the startup and shutdown order
+func Run(mods ...module.Module) error {
 	// logger
 	if conf.LogLevel != "" {
-		logger, err := log.New(conf.LogLevel, conf.LogPath, conf.LogFlag)
+		logger, err := log.NewWithRotation(conf.LogLevel, conf.LogPath, conf.LogFlag, log.Rotation{
+			MaxSize:    int64(conf.LogMaxSize) << 20,
+			Daily:      conf.LogDaily,
+			MaxBackups: conf.LogMaxBackups,
+			MaxAge:     conf.LogMaxAge,
+			Compress:   conf.LogCompress,
+		})
 		if err != nil {
 			panic(err)
 		}
+		encoder, err := log.NewEncoder(conf.LogFormat, conf.LogFlag)
+		if err != nil {
+			panic(err)
+		}
+		logger.SetEncoder(encoder)
 		log.Export(logger)
 		defer logger.Close()
 	}
 
 	log.Release("Leaf %v starting up", version)
 
-	// module
-	for i := 0; i < len(mods); i++ {
-		module.Register(mods[i])
+	app := NewApp(mods...)
+	app.DisableConsole = conf.DisableConsole
+	app.DisableCluster = conf.DisableCluster
+	err := app.Start(context.Background())
+	if err != nil {
+		log.Error("%v", err)
+		return err
 	}
-	module.Init()
-
-	// cluster
-	//cluster.Init()
-
-	// console
-	//console.Init()
 
 	// close
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	sig := <-c
 	log.Release("Leaf closing down (signal: %v)", sig)
-	//console.Destroy()
-	//cluster.Destroy()
-	module.Destroy()
+	go func() {
+		sig := <-c
+		log.Fatal("Leaf forced to exit (signal: %v)", sig)
+	}()
+
+	ctx := context.Background()
+	if conf.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.ShutdownTimeout)
+		defer cancel()
+	}
+	return app.Stop(ctx)
 }
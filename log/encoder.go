@@ -0,0 +1,206 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//Field a key/value pair attached to an entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+//F F
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+//Entry a log record passed to encoders and sinks
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+	// empty if the logger does not record the caller
+	File string
+	Line int
+}
+
+//Encoder desc:
+// encode an entry to bytes ended with a newline
+// must be goroutine safe
+type Encoder interface {
+	Encode(e *Entry) ([]byte, error)
+}
+
+//NewEncoder desc:
+// "text" or "" for TextEncoder with flag, "json" for JSONEncoder
+func NewEncoder(format string, flag int) (Encoder, error) {
+	switch strings.ToLower(format) {
+	case "", "text":
+		return &TextEncoder{Flag: flag}, nil
+	case "json":
+		return &JSONEncoder{}, nil
+	}
+	return nil, errors.New("unknown log format: " + format)
+}
+
+//TextEncoder desc:
+// the same output as the stdlib logger with Flag, fields are appended
+// to the message as key=value
+type TextEncoder struct {
+	Flag int
+}
+
+//Encode Encode
+func (enc *TextEncoder) Encode(e *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc.formatHeader(&buf, e)
+	buf.WriteString(e.Level.prefix())
+	buf.WriteString(e.Message)
+	for _, f := range e.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		buf.WriteString(textValue(f.Value))
+	}
+	if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (enc *TextEncoder) formatHeader(buf *bytes.Buffer, e *Entry) {
+	t := e.Time
+	if enc.Flag&log.LUTC != 0 {
+		t = t.UTC()
+	}
+	if enc.Flag&log.Ldate != 0 {
+		year, month, day := t.Date()
+		itoa(buf, year, 4)
+		buf.WriteByte('/')
+		itoa(buf, int(month), 2)
+		buf.WriteByte('/')
+		itoa(buf, day, 2)
+		buf.WriteByte(' ')
+	}
+	if enc.Flag&(log.Ltime|log.Lmicroseconds) != 0 {
+		hour, min, sec := t.Clock()
+		itoa(buf, hour, 2)
+		buf.WriteByte(':')
+		itoa(buf, min, 2)
+		buf.WriteByte(':')
+		itoa(buf, sec, 2)
+		if enc.Flag&log.Lmicroseconds != 0 {
+			buf.WriteByte('.')
+			itoa(buf, t.Nanosecond()/1e3, 6)
+		}
+		buf.WriteByte(' ')
+	}
+	if enc.Flag&(log.Lshortfile|log.Llongfile) != 0 && e.File != "" {
+		buf.WriteString(e.File)
+		buf.WriteByte(':')
+		itoa(buf, e.Line, -1)
+		buf.WriteString(": ")
+	}
+}
+
+// zero-padding to wid digits, no padding if wid < 0
+func itoa(buf *bytes.Buffer, i int, wid int) {
+	s := strconv.Itoa(i)
+	for n := len(s); n < wid; n++ {
+		buf.WriteByte('0')
+	}
+	buf.WriteString(s)
+}
+
+func textValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+//JSONEncoder desc:
+// one JSON object per line:
+// {"time":..,"level":..,"msg":..,"caller":..,<fields>...}
+// fields keep their order, errors and fmt.Stringers are written as strings
+// fields named time, level, msg or caller are written as fields.<name>
+type JSONEncoder struct {
+	// default to time.RFC3339Nano
+	TimeFormat string
+}
+
+//Encode Encode
+func (enc *JSONEncoder) Encode(e *Entry) ([]byte, error) {
+	timeFormat := enc.TimeFormat
+	if timeFormat == "" {
+		timeFormat = time.RFC3339Nano
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	jsonString(&buf, e.Time.Format(timeFormat))
+	buf.WriteString(`,"level":`)
+	jsonString(&buf, e.Level.String())
+	buf.WriteString(`,"msg":`)
+	jsonString(&buf, e.Message)
+	if e.File != "" {
+		buf.WriteString(`,"caller":`)
+		jsonString(&buf, e.File+":"+strconv.Itoa(e.Line))
+	}
+	for _, f := range e.Fields {
+		buf.WriteByte(',')
+		switch f.Key {
+		case "time", "level", "msg", "caller":
+			jsonString(&buf, "fields."+f.Key)
+		default:
+			jsonString(&buf, f.Key)
+		}
+		buf.WriteByte(':')
+		jsonValue(&buf, f.Value)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+func jsonString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
+
+func jsonValue(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case error:
+		jsonString(buf, v.Error())
+		return
+	case json.Marshaler:
+	case fmt.Stringer:
+		jsonString(buf, v.String())
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		jsonString(buf, fmt.Sprint(v))
+		return
+	}
+	buf.Write(data)
+}
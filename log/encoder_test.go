@@ -0,0 +1,149 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/log"
+)
+
+type stringer struct{}
+
+func (stringer) String() string {
+	return "stringer"
+}
+
+// the keys of a JSON object in order, duplicates included
+func jsonKeys(t *testing.T, data []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		t.Fatalf("%s: %v", data, err)
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		keys = append(keys, tok.(string))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+	}
+	return keys
+}
+
+func TestJSONEncoder(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		name   string
+		entry  log.Entry
+		keys   []string
+		values map[string]interface{}
+	}{
+		{
+			"plain",
+			log.Entry{Time: now, Level: log.ReleaseLevel, Message: "hello"},
+			[]string{"time", "level", "msg"},
+			map[string]interface{}{"time": "2024-05-06T07:08:09Z", "level": "release", "msg": "hello"},
+		},
+		{
+			"caller and fields",
+			log.Entry{
+				Time:    now,
+				Level:   log.ErrorLevel,
+				Message: "kicked",
+				File:    "gate.go",
+				Line:    42,
+				Fields: []log.Field{
+					log.F("player", 1001),
+					log.F("err", errors.New("timeout")),
+					log.F("s", stringer{}),
+					log.F("tags", []string{"a", "b"}),
+					log.F("chan", make(chan int)),
+				},
+			},
+			[]string{"time", "level", "msg", "caller", "player", "err", "s", "tags", "chan"},
+			map[string]interface{}{
+				"level":  "error",
+				"caller": "gate.go:42",
+				"player": float64(1001),
+				"err":    "timeout",
+				"s":      "stringer",
+				"tags":   []interface{}{"a", "b"},
+			},
+		},
+		{
+			"reserved keys",
+			log.Entry{
+				Time:    now,
+				Level:   log.DebugLevel,
+				Message: "hello",
+				File:    "a.go",
+				Line:    1,
+				Fields: []log.Field{
+					log.F("time", "later"),
+					log.F("level", 3),
+					log.F("msg", "bye"),
+					log.F("caller", "b.go"),
+				},
+			},
+			[]string{"time", "level", "msg", "caller", "fields.time", "fields.level", "fields.msg", "fields.caller"},
+			map[string]interface{}{
+				"time":          "2024-05-06T07:08:09Z",
+				"level":         "debug",
+				"msg":           "hello",
+				"caller":        "a.go:1",
+				"fields.time":   "later",
+				"fields.level":  float64(3),
+				"fields.msg":    "bye",
+				"fields.caller": "b.go",
+			},
+		},
+	}
+
+	enc := &log.JSONEncoder{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := enc.Encode(&test.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasSuffix(data, []byte("}\n")) {
+				t.Fatalf("%q", data)
+			}
+			if keys := jsonKeys(t, data); !reflect.DeepEqual(keys, test.keys) {
+				t.Fatalf("keys %v, want %v", keys, test.keys)
+			}
+			var values map[string]interface{}
+			if err := json.Unmarshal(data, &values); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range test.values {
+				if !reflect.DeepEqual(values[key], want) {
+					t.Fatalf("%v: %#v, want %#v", key, values[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONEncoderTimeFormat(t *testing.T) {
+	enc := &log.JSONEncoder{TimeFormat: "2006-01-02"}
+	data, err := enc.Encode(&log.Entry{Time: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["time"] != "2024-05-06" {
+		t.Fatalf("%v", values["time"])
+	}
+}
@@ -1,8 +1,10 @@
 package log_test
 
 import (
-	"github.com/somethinghero/leaf/log"
 	l "log"
+	"os"
+
+	"github.com/somethinghero/leaf/log"
 )
 
 func Example() {
@@ -27,3 +29,21 @@ func Example() {
 	log.Debug("will not print")
 	log.Release("My name is %v", name)
 }
+
+func ExampleLogger_With() {
+	logger, err := log.New("debug", "", 0)
+	if err != nil {
+		return
+	}
+	logger.SetSink(log.NewWriterSink(os.Stdout))
+
+	player := logger.With(log.F("module", "game"), log.F("player", 1001))
+	player.Releasew("login", log.F("name", "Leaf Hero"))
+	player.Error("kicked after %v failures", 3)
+
+	// logger.SetEncoder(&log.JSONEncoder{}) for one JSON object per line
+
+	// Output:
+	// [release] login module=game player=1001 name="Leaf Hero"
+	// [error  ] kicked after 3 failures module=game player=1001
+}
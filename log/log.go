@@ -6,16 +6,20 @@ import (
 	"log"
 	"os"
 	"path"
+	"runtime"
 	"strings"
 	"time"
 )
 
+//Level Level
+type Level int
+
 // levels
 const (
-	debugLevel   = 0
-	releaseLevel = 1
-	errorLevel   = 2
-	fatalLevel   = 3
+	DebugLevel Level = iota
+	ReleaseLevel
+	ErrorLevel
+	FatalLevel
 )
 
 const (
@@ -25,108 +29,204 @@ const (
 	printFatalLevel   = "[fatal  ] "
 )
 
+func (level Level) String() string {
+	switch level {
+	case DebugLevel:
+		return "debug"
+	case ReleaseLevel:
+		return "release"
+	case ErrorLevel:
+		return "error"
+	case FatalLevel:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+func (level Level) prefix() string {
+	switch level {
+	case DebugLevel:
+		return printDebugLevel
+	case ReleaseLevel:
+		return printReleaseLevel
+	case ErrorLevel:
+		return printErrorLevel
+	case FatalLevel:
+		return printFatalLevel
+	}
+	return ""
+}
+
 //Logger Logger
 type Logger struct {
-	level      int
-	baseLogger *log.Logger
-	baseFile   *os.File
+	level   Level
+	flag    int
+	encoder Encoder
+	sink    Sink
+	fields  []Field
 }
 
-//New New
+//New desc:
+// flag is the same as the flag of the stdlib logger, it is used by the
+// default TextEncoder and to decide whether to record the caller
 func New(strLevel string, pathname string, flag int) (*Logger, error) {
+	return NewWithRotation(strLevel, pathname, flag, Rotation{})
+}
+
+//NewWithRotation desc:
+// the same as New, log files in pathname are rotated and removed by rotation
+func NewWithRotation(strLevel string, pathname string, flag int, rotation Rotation) (*Logger, error) {
 	// level
-	var level int
+	var level Level
 	switch strings.ToLower(strLevel) {
 	case "debug":
-		level = debugLevel
+		level = DebugLevel
 	case "release":
-		level = releaseLevel
+		level = ReleaseLevel
 	case "error":
-		level = errorLevel
+		level = ErrorLevel
 	case "fatal":
-		level = fatalLevel
+		level = FatalLevel
 	default:
 		return nil, errors.New("unknown level: " + strLevel)
 	}
 
-	// logger
-	var baseLogger *log.Logger
-	var baseFile *os.File
+	// sink
+	var sink Sink
 	if pathname != "" {
-		now := time.Now()
-
-		filename := fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d.log",
-			now.Year(),
-			now.Month(),
-			now.Day(),
-			now.Hour(),
-			now.Minute(),
-			now.Second())
-
-		file, err := os.Create(path.Join(pathname, filename))
+		writer, err := NewRotateWriter(pathname, rotation)
 		if err != nil {
 			return nil, err
 		}
 
-		baseLogger = log.New(file, "", flag)
-		baseFile = file
+		sink = &writerSink{w: writer, closer: writer}
 	} else {
-		baseLogger = log.New(os.Stdout, "", flag)
+		sink = NewWriterSink(os.Stdout)
 	}
 
 	// new
 	logger := new(Logger)
 	logger.level = level
-	logger.baseLogger = baseLogger
-	logger.baseFile = baseFile
+	logger.flag = flag
+	logger.encoder = &TextEncoder{Flag: flag}
+	logger.sink = sink
 
 	return logger, nil
 }
 
+//SetEncoder It's dangerous to call the method on logging
+func (logger *Logger) SetEncoder(encoder Encoder) {
+	logger.encoder = encoder
+}
+
+//SetSink desc:
+// the previous sink is closed
+// It's dangerous to call the method on logging
+func (logger *Logger) SetSink(sink Sink) {
+	if logger.sink != nil {
+		logger.sink.Close()
+	}
+	logger.sink = sink
+}
+
+//With desc:
+// a logger adding fields to every entry, it shares the encoder and the sink
+// of logger, do not close it
+func (logger *Logger) With(fields ...Field) *Logger {
+	child := new(Logger)
+	*child = *logger
+	child.fields = make([]Field, 0, len(logger.fields)+len(fields))
+	child.fields = append(child.fields, logger.fields...)
+	child.fields = append(child.fields, fields...)
+	return child
+}
+
 //Close It's dangerous to call the method on logging
 func (logger *Logger) Close() {
-	if logger.baseFile != nil {
-		logger.baseFile.Close()
+	if logger.sink != nil {
+		logger.sink.Close()
 	}
 
-	logger.baseLogger = nil
-	logger.baseFile = nil
+	logger.sink = nil
 }
 
-func (logger *Logger) doPrintf(level int, printLevel string, format string, a ...interface{}) {
+// called by the exported functions only, to find the caller
+func (logger *Logger) output(level Level, msg string, fields []Field) {
 	if level < logger.level {
 		return
 	}
-	if logger.baseLogger == nil {
+	if logger.sink == nil {
 		panic("logger closed")
 	}
 
-	format = printLevel + format
-	logger.baseLogger.Output(3, fmt.Sprintf(format, a...))
+	e := &Entry{Time: time.Now(), Level: level, Message: msg}
+	if len(logger.fields) > 0 || len(fields) > 0 {
+		e.Fields = make([]Field, 0, len(logger.fields)+len(fields))
+		e.Fields = append(e.Fields, logger.fields...)
+		e.Fields = append(e.Fields, fields...)
+	}
+	if logger.flag&(log.Lshortfile|log.Llongfile) != 0 {
+		var ok bool
+		_, e.File, e.Line, ok = runtime.Caller(2)
+		if !ok {
+			e.File = "???"
+			e.Line = 0
+		} else if logger.flag&log.Lshortfile != 0 {
+			e.File = path.Base(e.File)
+		}
+	}
+
+	data, err := logger.encoder.Encode(e)
+	if err == nil {
+		err = logger.sink.Write(e, data)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log: %v\n", err)
+	}
 
-	if level == fatalLevel {
+	if level == FatalLevel {
 		os.Exit(1)
 	}
 }
 
 //Debug Debug
 func (logger *Logger) Debug(format string, a ...interface{}) {
-	logger.doPrintf(debugLevel, printDebugLevel, format, a...)
+	logger.output(DebugLevel, fmt.Sprintf(format, a...), nil)
 }
 
 //Release Release
 func (logger *Logger) Release(format string, a ...interface{}) {
-	logger.doPrintf(releaseLevel, printReleaseLevel, format, a...)
+	logger.output(ReleaseLevel, fmt.Sprintf(format, a...), nil)
 }
 
 //Error Error
 func (logger *Logger) Error(format string, a ...interface{}) {
-	logger.doPrintf(errorLevel, printErrorLevel, format, a...)
+	logger.output(ErrorLevel, fmt.Sprintf(format, a...), nil)
 }
 
 //Fatal Fatal
 func (logger *Logger) Fatal(format string, a ...interface{}) {
-	logger.doPrintf(fatalLevel, printFatalLevel, format, a...)
+	logger.output(FatalLevel, fmt.Sprintf(format, a...), nil)
+}
+
+//Debugw Debug with fields
+func (logger *Logger) Debugw(msg string, fields ...Field) {
+	logger.output(DebugLevel, msg, fields)
+}
+
+//Releasew Release with fields
+func (logger *Logger) Releasew(msg string, fields ...Field) {
+	logger.output(ReleaseLevel, msg, fields)
+}
+
+//Errorw Error with fields
+func (logger *Logger) Errorw(msg string, fields ...Field) {
+	logger.output(ErrorLevel, msg, fields)
+}
+
+//Fatalw Fatal with fields
+func (logger *Logger) Fatalw(msg string, fields ...Field) {
+	logger.output(FatalLevel, msg, fields)
 }
 
 var gLogger, _ = New("debug", "", log.LstdFlags)
@@ -140,22 +240,47 @@ func Export(logger *Logger) {
 
 //Debug Debug
 func Debug(format string, a ...interface{}) {
-	gLogger.doPrintf(debugLevel, printDebugLevel, format, a...)
+	gLogger.output(DebugLevel, fmt.Sprintf(format, a...), nil)
 }
 
 //Release Release
 func Release(format string, a ...interface{}) {
-	gLogger.doPrintf(releaseLevel, printReleaseLevel, format, a...)
+	gLogger.output(ReleaseLevel, fmt.Sprintf(format, a...), nil)
 }
 
 //Error Error
 func Error(format string, a ...interface{}) {
-	gLogger.doPrintf(errorLevel, printErrorLevel, format, a...)
+	gLogger.output(ErrorLevel, fmt.Sprintf(format, a...), nil)
 }
 
 //Fatal Fatal
 func Fatal(format string, a ...interface{}) {
-	gLogger.doPrintf(fatalLevel, printFatalLevel, format, a...)
+	gLogger.output(FatalLevel, fmt.Sprintf(format, a...), nil)
+}
+
+//Debugw Debug with fields
+func Debugw(msg string, fields ...Field) {
+	gLogger.output(DebugLevel, msg, fields)
+}
+
+//Releasew Release with fields
+func Releasew(msg string, fields ...Field) {
+	gLogger.output(ReleaseLevel, msg, fields)
+}
+
+//Errorw Error with fields
+func Errorw(msg string, fields ...Field) {
+	gLogger.output(ErrorLevel, msg, fields)
+}
+
+//Fatalw Fatal with fields
+func Fatalw(msg string, fields ...Field) {
+	gLogger.output(FatalLevel, msg, fields)
+}
+
+//With see Logger.With, the fields are added to the exported logger
+func With(fields ...Field) *Logger {
+	return gLogger.With(fields...)
 }
 
 //Close Close
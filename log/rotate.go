@@ -0,0 +1,286 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Rotation desc:
+// rotation and retention of log files, the zero value writes one file forever
+type Rotation struct {
+	// rotate when a file exceeds MaxSize bytes, 0 means no limit
+	MaxSize int64
+	// rotate at midnight (local time)
+	Daily bool
+	// number of rotated files to keep, 0 means no limit
+	MaxBackups int
+	// remove rotated files older than MaxAge, 0 means no limit
+	MaxAge time.Duration
+	// gzip rotated files
+	Compress bool
+}
+
+// the names created by RotateWriter, the suffix avoids conflicts in one second
+var logFileName = regexp.MustCompile(`^(\d{8}_\d{2}_\d{2}_\d{2})(?:-(\d+))?\.log(?:\.gz)?$`)
+
+//RotateWriter desc:
+// write to timestamped files in pathname, the same name format as New
+// goroutine safe
+type RotateWriter struct {
+	mutex    sync.Mutex
+	pathname string
+	rotation Rotation
+	file     *os.File
+	filename string
+	size     int64
+	day      int // yyyymmdd
+	wg       sync.WaitGroup
+	// closed when the cleanup of the last rotated file is done,
+	// rotated files are cleaned up one by one in order
+	cleanupDone chan struct{}
+}
+
+//NewRotateWriter desc:
+// the files left in pathname are cleaned up by the retention of rotation
+// and compressed if rotation.Compress is set, as if they were rotated
+func NewRotateWriter(pathname string, rotation Rotation) (*RotateWriter, error) {
+	writer := new(RotateWriter)
+	writer.pathname = pathname
+	writer.rotation = rotation
+	err := writer.open(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	writer.mutex.Lock()
+	writer.startCleanup("")
+	writer.mutex.Unlock()
+	return writer, nil
+}
+
+func (writer *RotateWriter) open(now time.Time) error {
+	name := fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d",
+		now.Year(),
+		now.Month(),
+		now.Day(),
+		now.Hour(),
+		now.Minute(),
+		now.Second())
+
+	filename := path.Join(writer.pathname, name+".log")
+	for n := 1; exists(filename) || exists(filename+".gz"); n++ {
+		filename = path.Join(writer.pathname, fmt.Sprintf("%v-%v.log", name, n))
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+
+	writer.file = file
+	writer.filename = filename
+	writer.size = 0
+	writer.day = date(now)
+	return nil
+}
+
+//Write Write
+func (writer *RotateWriter) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	now := time.Now()
+	if writer.rotation.Daily && date(now) != writer.day ||
+		writer.rotation.MaxSize > 0 && writer.size > 0 &&
+			writer.size+int64(len(p)) > writer.rotation.MaxSize {
+		err := writer.rotate(now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := writer.file.Write(p)
+	writer.size += int64(n)
+	return n, err
+}
+
+//Rotate close the current file and write to a new one
+func (writer *RotateWriter) Rotate() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file == nil {
+		return os.ErrClosed
+	}
+	return writer.rotate(time.Now())
+}
+
+func date(t time.Time) int {
+	year, month, day := t.Date()
+	return year*10000 + int(month)*100 + day
+}
+
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return !os.IsNotExist(err)
+}
+
+func (writer *RotateWriter) rotate(now time.Time) error {
+	old := writer.file
+	err := writer.open(now)
+	if err != nil {
+		// keep writing to the old file
+		return err
+	}
+	old.Close()
+
+	writer.startCleanup(old.Name())
+	return nil
+}
+
+// the mutex must be held
+func (writer *RotateWriter) startCleanup(rotated string) {
+	prev := writer.cleanupDone
+	done := make(chan struct{})
+	writer.cleanupDone = done
+	writer.wg.Add(1)
+	go func() {
+		defer writer.wg.Done()
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		writer.cleanup(rotated)
+	}()
+}
+
+// rotated is empty when the writer is created, all files left are cleaned up
+func (writer *RotateWriter) cleanup(rotated string) {
+	if writer.rotation.Compress && rotated != "" {
+		writer.compress(rotated)
+	}
+
+	compressAll := writer.rotation.Compress && rotated == ""
+	if writer.rotation.MaxBackups <= 0 && writer.rotation.MaxAge <= 0 && !compressAll {
+		return
+	}
+
+	entries, err := os.ReadDir(writer.pathname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log: %v\n", err)
+		return
+	}
+
+	writer.mutex.Lock()
+	current := path.Base(writer.filename)
+	writer.mutex.Unlock()
+
+	type backup struct {
+		entry os.DirEntry
+		time  string
+		n     int
+	}
+	var backups []backup
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == current {
+			continue
+		}
+		match := logFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(match[2])
+		backups = append(backups, backup{entry, match[1], n})
+	}
+	// the newest first
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].time != backups[j].time {
+			return backups[i].time > backups[j].time
+		}
+		return backups[i].n > backups[j].n
+	})
+
+	for i, b := range backups {
+		entry := b.entry
+		remove := writer.rotation.MaxBackups > 0 && i >= writer.rotation.MaxBackups
+		if !remove && writer.rotation.MaxAge > 0 {
+			info, err := entry.Info()
+			remove = err == nil && time.Since(info.ModTime()) > writer.rotation.MaxAge
+		}
+		filename := path.Join(writer.pathname, entry.Name())
+		if remove {
+			os.Remove(filename)
+		} else if compressAll && !strings.HasSuffix(filename, ".gz") {
+			writer.compress(filename)
+		}
+	}
+}
+
+func (writer *RotateWriter) compress(filename string) {
+	err := compress(filename)
+	// removed by MaxAge or MaxBackups
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "log: compress %v error: %v\n", filename, err)
+	}
+}
+
+func compress(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(filename+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = dst.Close()
+	} else {
+		dst.Close()
+	}
+	if err != nil {
+		os.Remove(filename + ".gz")
+		return err
+	}
+
+	// for MaxAge
+	os.Chtimes(filename+".gz", info.ModTime(), info.ModTime())
+	return os.Remove(filename)
+}
+
+//Close close the current file and wait for the cleanup of rotated files
+func (writer *RotateWriter) Close() error {
+	writer.mutex.Lock()
+	var err error
+	if writer.file != nil {
+		err = writer.file.Close()
+		writer.file = nil
+	}
+	writer.mutex.Unlock()
+
+	writer.wg.Wait()
+	return err
+}
@@ -0,0 +1,185 @@
+package log_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/log"
+)
+
+// contents of the log files in dir, the oldest first
+func readLogs(t *testing.T, dir string) (names []string, contents []string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	// the current file is the newest, name-n is newer than name
+	key := func(name string) string {
+		base := strings.SplitN(name, ".", 2)[0]
+		if i := strings.IndexByte(base, '-'); i >= 0 {
+			return base[:i] + fmt.Sprintf("-%08s", base[i+1:])
+		}
+		return base
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return key(names[i]) < key(names[j])
+	})
+
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+		data, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	return
+}
+
+func writeLines(t *testing.T, writer *log.RotateWriter, lines ...string) {
+	for _, line := range lines {
+		if _, err := writer.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRotateWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rotation log.Rotation
+		// the files left, the oldest first
+		contents []string
+		gz       int
+	}{
+		{
+			name:     "size",
+			rotation: log.Rotation{MaxSize: 10},
+			contents: []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n", "line 5\n"},
+		},
+		{
+			name:     "max backups",
+			rotation: log.Rotation{MaxSize: 10, MaxBackups: 2},
+			contents: []string{"line 3\n", "line 4\n", "line 5\n"},
+		},
+		{
+			name:     "compress",
+			rotation: log.Rotation{MaxSize: 10, MaxBackups: 3, Compress: true},
+			contents: []string{"line 2\n", "line 3\n", "line 4\n", "line 5\n"},
+			gz:       3,
+		},
+		{
+			name:     "no limit",
+			rotation: log.Rotation{},
+			contents: []string{"line 1\nline 2\nline 3\nline 4\nline 5\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writer, err := log.NewRotateWriter(dir, test.rotation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeLines(t, writer, "line 1\n", "line 2\n", "line 3\n", "line 4\n", "line 5\n")
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			names, contents := readLogs(t, dir)
+			if strings.Join(contents, "|") != strings.Join(test.contents, "|") {
+				t.Fatalf("contents: %q, want %q (files %v)", contents, test.contents, names)
+			}
+			gz := 0
+			for _, name := range names {
+				if strings.HasSuffix(name, ".gz") {
+					gz++
+				}
+			}
+			if gz != test.gz {
+				t.Fatalf("%v gzip files, want %v (files %v)", gz, test.gz, names)
+			}
+		})
+	}
+}
+
+// the files of a previous process are cleaned up when the writer is created
+func TestRotateWriterRetentionAtOpen(t *testing.T) {
+	dir := t.TempDir()
+	old := []string{
+		"20200101_00_00_01.log",
+		"20200101_00_00_02.log",
+		"20200101_00_00_03.log.gz",
+		"20200101_00_00_04.log",
+		"20200101_00_00_04-1.log",
+		"other.txt",
+	}
+	for _, name := range old {
+		data := []byte(name)
+		if strings.HasSuffix(name, ".gz") {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write(data)
+			gz.Close()
+			data = buf.Bytes()
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// removed by MaxAge
+	expired := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "20200101_00_00_02.log"), expired, expired)
+
+	// 20200101_00_00_01.log is removed by MaxBackups
+	writer, err := log.NewRotateWriter(dir, log.Rotation{MaxBackups: 4, MaxAge: time.Hour, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := readLogs(t, dir)
+	want := []string{"20200101_00_00_03.log.gz", "20200101_00_00_04.log.gz", "20200101_00_00_04-1.log.gz"}
+	var got []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "2020") {
+			got = append(got, name)
+		}
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("files: %v, want %v", names, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.txt")); err != nil {
+		t.Fatalf("other files must be kept: %v", err)
+	}
+	// the current file
+	if len(names) != len(want)+2 {
+		t.Fatalf("files: %v", names)
+	}
+}
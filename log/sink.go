@@ -0,0 +1,40 @@
+package log
+
+import (
+	"io"
+	"sync"
+)
+
+//Sink desc:
+// the destination of encoded entries, p is the output of the encoder
+// must be goroutine safe
+type Sink interface {
+	Write(e *Entry, p []byte) error
+	Close() error
+}
+
+//NewWriterSink desc:
+// write entries to w in order, Close does not close w
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+type writerSink struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func (sink *writerSink) Write(e *Entry, p []byte) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	_, err := sink.w.Write(p)
+	return err
+}
+
+func (sink *writerSink) Close() error {
+	if sink.closer != nil {
+		return sink.closer.Close()
+	}
+	return nil
+}
@@ -1,8 +1,13 @@
 package module
 
 import (
+	"context"
+	"fmt"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/somethinghero/leaf/conf"
 	"github.com/somethinghero/leaf/log"
@@ -15,49 +20,294 @@ type Module interface {
 	Run(closeSig chan bool)
 }
 
+//Namer optional, the name of a module, default to its type name
+type Namer interface {
+	Name() string
+}
+
+//Depender optional, names of the modules which must be initialized before the module
+type Depender interface {
+	Dependencies() []string
+}
+
+//Starter optional, Start is called after OnInit of all modules and right
+// before Run, Init fails if Start returns an error
+type Starter interface {
+	Start() error
+}
+
 type module struct {
 	mi       Module
+	name     string
+	deps     []string
+	policy   Policy
+	state    int32
 	closeSig chan bool
 	wg       sync.WaitGroup
 }
 
-var mods []*module
+func (m *module) setState(state State) {
+	atomic.StoreInt32(&m.state, int32(state))
+}
+
+func (m *module) getState() State {
+	return State(atomic.LoadInt32(&m.state))
+}
+
+//Manager desc:
+// a set of modules started and destroyed together
+// goroutine not safe
+type Manager struct {
+	mods      []*module
+	panicHook func(Event)
+}
+
+var defaultManager = NewManager()
+
+//NewManager NewManager
+func NewManager() *Manager {
+	return new(Manager)
+}
 
 //Register Register
 func Register(mi Module) {
+	defaultManager.Register(mi)
+}
+
+//Init Init
+func Init() error {
+	return defaultManager.Init()
+}
+
+//Destroy Destroy
+func Destroy() {
+	defaultManager.Destroy()
+}
+
+//DestroyContext see Manager.DestroyContext
+func DestroyContext(ctx context.Context) error {
+	return defaultManager.DestroyContext(ctx)
+}
+
+//GetState see Manager.State
+func GetState(name string) (State, bool) {
+	return defaultManager.State(name)
+}
+
+//Register Register
+func (mgr *Manager) Register(mi Module) {
 	m := new(module)
 	m.mi = mi
+	if namer, ok := mi.(Namer); ok {
+		m.name = namer.Name()
+	} else {
+		// modules of the same type without a name are allowed
+		m.name = fmt.Sprintf("%T", mi)
+		for n := 2; mgr.has(m.name); n++ {
+			m.name = fmt.Sprintf("%T#%v", mi, n)
+		}
+	}
+	if depender, ok := mi.(Depender); ok {
+		m.deps = depender.Dependencies()
+	}
+	if supervised, ok := mi.(Supervised); ok {
+		m.policy = supervised.Policy()
+	}
 	m.closeSig = make(chan bool, 1)
 
-	mods = append(mods, m)
+	mgr.mods = append(mgr.mods, m)
 }
 
-//Init Init
-func Init() {
+func (mgr *Manager) has(name string) bool {
+	for _, m := range mgr.mods {
+		if m.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//Init desc:
+// sort modules so that every module is initialized after its dependencies,
+// modules without dependencies between them keep the registration order
+// return an error without initializing any module if a dependency is missing
+// or there is a dependency cycle
+// if Start of a module returns an error, the module and the modules after it
+// are destroyed without running and the error is returned, the modules
+// already running are stopped by Destroy
+func (mgr *Manager) Init() error {
+	mods, err := mgr.sort()
+	if err != nil {
+		return err
+	}
+	mgr.mods = mods
+
 	for i := 0; i < len(mods); i++ {
+		mods[i].setState(StateInitializing)
 		mods[i].mi.OnInit()
 	}
 
 	for i := 0; i < len(mods); i++ {
 		m := mods[i]
+		if starter, ok := m.mi.(Starter); ok {
+			err := starter.Start()
+			if err != nil {
+				for j := len(mods) - 1; j >= i; j-- {
+					destroy(mods[j])
+					mods[j].setState(StateStopped)
+				}
+				return fmt.Errorf("module %v start error: %v", m.name, err)
+			}
+		}
+		m.setState(StateRunning)
 		m.wg.Add(1)
-		go run(m)
+		go mgr.run(m)
 	}
+	return nil
+}
+
+func (mgr *Manager) sort() ([]*module, error) {
+	byName := make(map[string]*module, len(mgr.mods))
+	for _, m := range mgr.mods {
+		if _, ok := byName[m.name]; ok {
+			return nil, fmt.Errorf("module %v is already registered", m.name)
+		}
+		byName[m.name] = m
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	mark := make(map[*module]int)
+	sorted := make([]*module, 0, len(mgr.mods))
+	var path []string
+
+	var visit func(m *module) error
+	visit = func(m *module) error {
+		switch mark[m] {
+		case visited:
+			return nil
+		case visiting:
+			for i := range path {
+				if path[i] == m.name {
+					path = append(path[i:], m.name)
+					break
+				}
+			}
+			return fmt.Errorf("module dependency cycle: %v", strings.Join(path, " -> "))
+		}
+
+		mark[m] = visiting
+		path = append(path, m.name)
+		for _, dep := range m.deps {
+			d, ok := byName[dep]
+			if !ok {
+				return fmt.Errorf("module %v depends on unregistered module %v", m.name, dep)
+			}
+			err := visit(d)
+			if err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		mark[m] = visited
+
+		sorted = append(sorted, m)
+		return nil
+	}
+
+	for _, m := range mgr.mods {
+		err := visit(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+//State goroutine safe after Init
+func (mgr *Manager) State(name string) (State, bool) {
+	for _, m := range mgr.mods {
+		if m.name == name {
+			return m.getState(), true
+		}
+	}
+	return StateStopped, false
+}
+
+//Names names of all modules, in the initialization order after Init
+func (mgr *Manager) Names() []string {
+	names := make([]string, len(mgr.mods))
+	for i, m := range mgr.mods {
+		names[i] = m.name
+	}
+	return names
 }
 
 //Destroy Destroy
-func Destroy() {
+func (mgr *Manager) Destroy() {
+	mgr.DestroyContext(context.Background())
+}
+
+//DestroyContext desc:
+// destroy modules in reverse order, every module gets an equal share of
+// the time left before the deadline of ctx to return from Run
+// a module failed to stop in time is reported and not destroyed
+func (mgr *Manager) DestroyContext(ctx context.Context) error {
+	mods := mgr.mods
+	var timeout []string
 	for i := len(mods) - 1; i >= 0; i-- {
 		m := mods[i]
+		if m.getState() == StateStopped {
+			continue
+		}
+
+		m.setState(StateStopping)
 		m.closeSig <- true
-		m.wg.Wait()
+		if !wait(ctx, m, i+1) {
+			m.setState(StateFailed)
+			timeout = append(timeout, m.name)
+			continue
+		}
 		destroy(m)
+		m.setState(StateStopped)
+	}
+
+	if len(timeout) > 0 {
+		err := fmt.Errorf("modules failed to stop in time: %v", timeout)
+		log.Error("%v", err)
+		return err
 	}
+	return nil
 }
 
-func run(m *module) {
-	m.mi.Run(m.closeSig)
-	m.wg.Done()
+// n modules left to stop, including m
+func wait(ctx context.Context, m *module, n int) bool {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	var chanTimeout <-chan time.Time
+	if deadline, ok := ctx.Deadline(); ok {
+		budget := time.Until(deadline) / time.Duration(n)
+		t := time.NewTimer(budget)
+		defer t.Stop()
+		chanTimeout = t.C
+	}
+
+	begin := time.Now()
+	select {
+	case <-done:
+		return true
+	case <-chanTimeout:
+	case <-ctx.Done():
+	}
+
+	log.Error("module %v failed to stop in %v", m.name, time.Since(begin))
+	return false
 }
 
 func destroy(m *module) {
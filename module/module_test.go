@@ -0,0 +1,120 @@
+package module_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/module"
+)
+
+type testModule struct {
+	name string
+	// Run does not return before release is closed
+	release   chan struct{}
+	destroyed chan time.Time
+}
+
+func newTestModule(name string, slow bool) *testModule {
+	m := &testModule{name: name, destroyed: make(chan time.Time, 1)}
+	if slow {
+		m.release = make(chan struct{})
+	}
+	return m
+}
+
+func (m *testModule) Name() string {
+	return m.name
+}
+
+func (m *testModule) OnInit() {}
+
+func (m *testModule) OnDestroy() {
+	m.destroyed <- time.Now()
+}
+
+func (m *testModule) Run(closeSig chan bool) {
+	<-closeSig
+	if m.release != nil {
+		<-m.release
+	}
+}
+
+func TestDestroyContext(t *testing.T) {
+	mgr := module.NewManager()
+	a := newTestModule("a", false)
+	b := newTestModule("b", true)
+	c := newTestModule("c", true)
+	for _, m := range []*testModule{a, b, c} {
+		mgr.Register(m)
+	}
+	if err := mgr.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer close(b.release)
+	defer close(c.release)
+
+	// c and b are destroyed first and get a third and a half of the time
+	// left, a is destroyed after about 400ms
+	begin := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	err := mgr.DestroyContext(ctx)
+	if err == nil || !strings.Contains(err.Error(), "[c b]") {
+		t.Fatalf("%v", err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		state module.State
+	}{
+		{"a", module.StateStopped},
+		{"b", module.StateFailed},
+		{"c", module.StateFailed},
+	} {
+		if state, _ := mgr.State(test.name); state != test.state {
+			t.Fatalf("%v: %v, want %v", test.name, state, test.state)
+		}
+	}
+
+	select {
+	case at := <-a.destroyed:
+		if d := at.Sub(begin); d < 300*time.Millisecond || d > 550*time.Millisecond {
+			t.Fatalf("a destroyed after %v", d)
+		}
+	default:
+		t.Fatal("a is not destroyed")
+	}
+	for _, m := range []*testModule{b, c} {
+		select {
+		case <-m.destroyed:
+			t.Fatalf("%v is destroyed", m.name)
+		default:
+		}
+	}
+}
+
+func TestDestroyContextNoDeadline(t *testing.T) {
+	mgr := module.NewManager()
+	a := newTestModule("a", false)
+	b := newTestModule("b", true)
+	mgr.Register(a)
+	mgr.Register(b)
+	if err := mgr.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// waits for b
+	time.AfterFunc(100*time.Millisecond, func() { close(b.release) })
+	if err := mgr.DestroyContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*testModule{a, b} {
+		select {
+		case <-m.destroyed:
+		default:
+			t.Fatalf("%v is not destroyed", m.name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package module
 
 import (
+	"context"
 	"time"
 
 	"github.com/somethinghero/leaf/chanrpc"
@@ -117,6 +118,28 @@ func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...inte
 	s.client.AsynCall(id, args...)
 }
 
+//AsynCallContext AsynCallContext
+func (s *Skeleton) AsynCallContext(ctx context.Context, server *chanrpc.Server, id interface{}, args ...interface{}) {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	s.client.Attach(server)
+	s.client.AsynCallContext(ctx, id, args...)
+}
+
+//AsynClient desc:
+// attach server to the asyn call client of the skeleton and return it,
+// use it with chanrpc.Func.AsynCall
+func (s *Skeleton) AsynClient(server *chanrpc.Server) *chanrpc.Client {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	s.client.Attach(server)
+	return s.client
+}
+
 //RegisterChanRPC RegisterChanRPC
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	if s.ChanRPCServer == nil {
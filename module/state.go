@@ -0,0 +1,29 @@
+package module
+
+//State lifecycle state of a module
+type State int32
+
+// states
+const (
+	StateStopped State = iota
+	StateInitializing
+	StateRunning
+	StateStopping
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateInitializing:
+		return "initializing"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
@@ -0,0 +1,149 @@
+package module
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/somethinghero/leaf/conf"
+	"github.com/somethinghero/leaf/log"
+)
+
+//Strategy what to do when the Run of a module panics
+type Strategy int
+
+// strategies
+const (
+	// log and exit the process
+	Crash Strategy = iota
+	// call Run again after a backoff
+	Restart
+	// mark the module failed and keep the others running
+	Fail
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case Crash:
+		return "crash"
+	case Restart:
+		return "restart"
+	case Fail:
+		return "fail"
+	}
+	return "unknown"
+}
+
+//Policy supervision policy of a module
+type Policy struct {
+	Strategy Strategy
+	// Restart: a module restarted MaxRestarts times is marked failed,
+	// 0 means no limit
+	MaxRestarts int
+	// Restart: the first backoff, doubled after every restart, default 1s
+	Backoff time.Duration
+	// Restart: default 1m
+	MaxBackoff time.Duration
+}
+
+//Supervised optional, the supervision policy of a module, default to Crash
+type Supervised interface {
+	Policy() Policy
+}
+
+//Event a panic in the Run of a module
+type Event struct {
+	Module   string
+	Panic    interface{}
+	Stack    string
+	Restarts int
+	Action   Strategy
+}
+
+//SetPanicHook see Manager.SetPanicHook
+func SetPanicHook(f func(Event)) {
+	defaultManager.SetPanicHook(f)
+}
+
+//SetPanicHook desc:
+// f is called in the goroutine of the module after its Run panics
+// you must call the function before calling Init
+func (mgr *Manager) SetPanicHook(f func(Event)) {
+	mgr.panicHook = f
+}
+
+func (mgr *Manager) run(m *module) {
+	defer m.wg.Done()
+
+	policy := m.policy
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+
+	for restarts := 0; ; restarts++ {
+		r, stack := runSafe(m)
+		if r == nil {
+			return
+		}
+
+		e := Event{
+			Module:   m.name,
+			Panic:    r,
+			Stack:    stack,
+			Restarts: restarts,
+			Action:   policy.Strategy,
+		}
+		if e.Action == Restart {
+			// do not restart a module being stopped
+			if m.getState() == StateStopping ||
+				policy.MaxRestarts > 0 && restarts >= policy.MaxRestarts {
+				e.Action = Fail
+			}
+		}
+		if stack != "" {
+			log.Error("module %v panic: %v: %s", m.name, r, stack)
+		} else {
+			log.Error("module %v panic: %v", m.name, r)
+		}
+		if mgr.panicHook != nil {
+			mgr.panicHook(e)
+		}
+
+		switch e.Action {
+		case Restart:
+			log.Release("module %v restarting in %v (%v restarts)", m.name, backoff, restarts)
+			select {
+			case <-m.closeSig:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		case Fail:
+			m.setState(StateFailed)
+			log.Error("module %v failed", m.name)
+			return
+		default:
+			log.Fatal("module %v crashed", m.name)
+		}
+	}
+}
+
+func runSafe(m *module) (r interface{}, stack string) {
+	defer func() {
+		if r = recover(); r != nil && conf.LenStackBuf > 0 {
+			buf := make([]byte, conf.LenStackBuf)
+			l := runtime.Stack(buf, false)
+			stack = string(buf[:l])
+		}
+	}()
+
+	m.mi.Run(m.closeSig)
+	return
+}
@@ -0,0 +1,245 @@
+package module_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/module"
+)
+
+// the test binary is run again to crash
+const envCrash = "LEAF_MODULE_TEST_CRASH"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envCrash) != "" {
+		crash()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// panics the first panics times Run is called
+type panicModule struct {
+	name   string
+	policy module.Policy
+	panics int
+
+	mutex sync.Mutex
+	runs  []time.Time
+}
+
+func (m *panicModule) Name() string {
+	return m.name
+}
+
+func (m *panicModule) Policy() module.Policy {
+	return m.policy
+}
+
+func (m *panicModule) OnInit() {}
+
+func (m *panicModule) OnDestroy() {}
+
+func (m *panicModule) Run(closeSig chan bool) {
+	m.mutex.Lock()
+	m.runs = append(m.runs, time.Now())
+	runs := len(m.runs)
+	m.mutex.Unlock()
+
+	if m.panics < 0 || runs <= m.panics {
+		panic(fmt.Sprintf("run %v", runs))
+	}
+	<-closeSig
+}
+
+func (m *panicModule) getRuns() []time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]time.Time(nil), m.runs...)
+}
+
+type events struct {
+	mutex  sync.Mutex
+	events []module.Event
+}
+
+func (e *events) hook(event module.Event) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *events) get() []module.Event {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]module.Event(nil), e.events...)
+}
+
+func startSupervised(t *testing.T, mods ...module.Module) (*module.Manager, *events) {
+	mgr := module.NewManager()
+	e := new(events)
+	mgr.SetPanicHook(e.hook)
+	for _, m := range mods {
+		mgr.Register(m)
+	}
+	if err := mgr.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mgr.Destroy)
+	return mgr, e
+}
+
+func waitState(t *testing.T, mgr *module.Manager, name string, state module.State) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, _ := mgr.State(name)
+		if s == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v: %v, want %v", name, s, state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitRuns(t *testing.T, m *panicModule, n int) []time.Time {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runs := m.getRuns()
+		if len(runs) >= n {
+			return runs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v runs, want %v", len(runs), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	m := &panicModule{
+		name:   "m",
+		policy: module.Policy{Strategy: module.Restart, Backoff: 20 * time.Millisecond, MaxBackoff: 80 * time.Millisecond},
+		panics: 4,
+	}
+	mgr, e := startSupervised(t, m)
+
+	// backoffs of 20ms, 40ms, 80ms and 80ms
+	runs := waitRuns(t, m, 5)
+	for i, want := range []time.Duration{20, 40, 80, 80} {
+		want *= time.Millisecond
+		if d := runs[i+1].Sub(runs[i]); d < want || d > want+200*time.Millisecond {
+			t.Fatalf("backoff %v: %v, want %v", i, d, want)
+		}
+	}
+	waitState(t, mgr, "m", module.StateRunning)
+
+	events := e.get()
+	if len(events) != 4 {
+		t.Fatalf("%v events", len(events))
+	}
+	for i, event := range events {
+		if event.Module != "m" || event.Restarts != i || event.Action != module.Restart ||
+			event.Panic != fmt.Sprintf("run %v", i+1) {
+			t.Fatalf("%+v", event)
+		}
+	}
+}
+
+func TestRestartMax(t *testing.T) {
+	m := &panicModule{
+		name:   "m",
+		policy: module.Policy{Strategy: module.Restart, MaxRestarts: 2, Backoff: time.Millisecond},
+		panics: -1,
+	}
+	other := newTestModule("other", false)
+	mgr, e := startSupervised(t, m, other)
+
+	waitState(t, mgr, "m", module.StateFailed)
+	if runs := m.getRuns(); len(runs) != 3 {
+		t.Fatalf("%v runs", len(runs))
+	}
+	var actions []module.Strategy
+	for _, event := range e.get() {
+		actions = append(actions, event.Action)
+	}
+	if fmt.Sprint(actions) != "[restart restart fail]" {
+		t.Fatalf("%v", actions)
+	}
+	if state, _ := mgr.State("other"); state != module.StateRunning {
+		t.Fatalf("other: %v", state)
+	}
+}
+
+func TestFail(t *testing.T) {
+	m := &panicModule{
+		name:   "m",
+		policy: module.Policy{Strategy: module.Fail},
+		panics: 1,
+	}
+	mgr, e := startSupervised(t, m)
+
+	waitState(t, mgr, "m", module.StateFailed)
+	events := e.get()
+	if len(events) != 1 || events[0].Action != module.Fail || events[0].Restarts != 0 {
+		t.Fatalf("%+v", events)
+	}
+	if runs := m.getRuns(); len(runs) != 1 {
+		t.Fatalf("%v runs", len(runs))
+	}
+}
+
+// the module is not restarted once it is being stopped
+func TestRestartStopping(t *testing.T) {
+	m := &panicModule{
+		name:   "m",
+		policy: module.Policy{Strategy: module.Restart, Backoff: time.Hour},
+		panics: 1,
+	}
+	mgr, _ := startSupervised(t, m)
+	waitRuns(t, m, 1)
+
+	done := make(chan struct{})
+	go func() {
+		mgr.Destroy()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Destroy waits for the backoff")
+	}
+	if runs := m.getRuns(); len(runs) != 1 {
+		t.Fatalf("%v runs", len(runs))
+	}
+}
+
+func crash() {
+	mgr := module.NewManager()
+	mgr.SetPanicHook(func(e module.Event) {
+		fmt.Printf("hook %v %v\n", e.Module, e.Action)
+	})
+	mgr.Register(&panicModule{name: "m", panics: 1})
+	mgr.Init()
+	time.Sleep(5 * time.Second)
+	fmt.Println("not crashed")
+}
+
+// the default policy exits the process
+func TestCrash(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestCrash")
+	cmd.Env = append(os.Environ(), envCrash+"=1")
+	output, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("%v: %s", err, output)
+	}
+	if !strings.Contains(string(output), "hook m crash") || strings.Contains(string(output), "not crashed") {
+		t.Fatalf("%s", output)
+	}
+}
@@ -1,7 +1,17 @@
 package network
 
 import (
+	"errors"
 	"net"
+	"sync"
+	"time"
+)
+
+// errors of connections
+var (
+	ErrConnClosed       = errors.New("conn closed")
+	ErrWriteChannelFull = errors.New("write channel full")
+	ErrShutdown         = errors.New("server shutdown")
 )
 
 //Conn connection interface
@@ -13,3 +23,27 @@ type Conn interface {
 	Close()
 	Destroy()
 }
+
+// return false if wg is not done after timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+//IsTimeout whether err is caused by a deadline, e.g. the idle timeout of a connection
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
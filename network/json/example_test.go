@@ -0,0 +1,56 @@
+package json_test
+
+import (
+	"fmt"
+
+	"github.com/somethinghero/leaf/network/json"
+)
+
+type Hello struct {
+	Name string
+}
+
+type Move struct {
+	X, Y int
+}
+
+func ExampleProcessor() {
+	p := json.NewProcessor()
+	p.Register(&Hello{})
+	p.RegisterWithID(&Move{}, 10)
+	p.SetHandler(&Hello{}, func(args []interface{}) {
+		fmt.Println("hello", args[0].(*Hello).Name)
+	})
+
+	msg, err := p.Unmarshal([]byte(`{"Hello": {"Name": "leaf"}}`))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Route(msg, nil)
+
+	data, _ := p.Marshal(&Move{1, 2})
+	fmt.Println(string(data[0]))
+
+	// numeric ids
+	p.SetEnvelope(json.IDEnvelope)
+	p.SetRawHandler("Move", func(args []interface{}) {
+		fmt.Printf("raw %v %s\n", args[0], args[1])
+	})
+
+	msg, err = p.Unmarshal([]byte(`{"id": 10, "data": {"X": 3, "Y": 4}}`))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Route(msg, nil)
+
+	data, _ = p.Marshal(&Hello{"leaf"})
+	fmt.Println(string(data[0]))
+
+	// Output:
+	// hello leaf
+	// {"Move":{"X":1,"Y":2}}
+	// raw Move {"X": 3, "Y": 4}
+	// {"id":0,"data":{"Name":"leaf"}}
+}
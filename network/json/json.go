@@ -1,173 +1,286 @@
 package json
 
-// import (
-// 	"encoding/json"
-// 	"errors"
-// 	"fmt"
-// 	"github.com/somethinghero/leaf/chanrpc"
-// 	"github.com/somethinghero/leaf/log"
-// 	"reflect"
-// )
-
-// type Processor struct {
-// 	msgInfo map[string]*MsgInfo
-// }
-
-// type MsgInfo struct {
-// 	msgType       reflect.Type
-// 	msgRouter     *chanrpc.Server
-// 	msgHandler    MsgHandler
-// 	msgRawHandler MsgHandler
-// }
-
-// type MsgHandler func([]interface{})
-
-// type MsgRaw struct {
-// 	msgID      string
-// 	msgRawData json.RawMessage
-// }
-
-// func NewProcessor() *Processor {
-// 	p := new(Processor)
-// 	p.msgInfo = make(map[string]*MsgInfo)
-// 	return p
-// }
-
-// // It's dangerous to call the method on routing or marshaling (unmarshaling)
-// func (p *Processor) Register(msg interface{}) string {
-// 	msgType := reflect.TypeOf(msg)
-// 	if msgType == nil || msgType.Kind() != reflect.Ptr {
-// 		log.Fatal("json message pointer required")
-// 	}
-// 	msgID := msgType.Elem().Name()
-// 	if msgID == "" {
-// 		log.Fatal("unnamed json message")
-// 	}
-// 	if _, ok := p.msgInfo[msgID]; ok {
-// 		log.Fatal("message %v is already registered", msgID)
-// 	}
-
-// 	i := new(MsgInfo)
-// 	i.msgType = msgType
-// 	p.msgInfo[msgID] = i
-// 	return msgID
-// }
-
-// // It's dangerous to call the method on routing or marshaling (unmarshaling)
-// func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
-// 	msgType := reflect.TypeOf(msg)
-// 	if msgType == nil || msgType.Kind() != reflect.Ptr {
-// 		log.Fatal("json message pointer required")
-// 	}
-// 	msgID := msgType.Elem().Name()
-// 	i, ok := p.msgInfo[msgID]
-// 	if !ok {
-// 		log.Fatal("message %v not registered", msgID)
-// 	}
-
-// 	i.msgRouter = msgRouter
-// }
-
-// // It's dangerous to call the method on routing or marshaling (unmarshaling)
-// func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
-// 	msgType := reflect.TypeOf(msg)
-// 	if msgType == nil || msgType.Kind() != reflect.Ptr {
-// 		log.Fatal("json message pointer required")
-// 	}
-// 	msgID := msgType.Elem().Name()
-// 	i, ok := p.msgInfo[msgID]
-// 	if !ok {
-// 		log.Fatal("message %v not registered", msgID)
-// 	}
-
-// 	i.msgHandler = msgHandler
-// }
-
-// // It's dangerous to call the method on routing or marshaling (unmarshaling)
-// func (p *Processor) SetRawHandler(msgID string, msgRawHandler MsgHandler) {
-// 	i, ok := p.msgInfo[msgID]
-// 	if !ok {
-// 		log.Fatal("message %v not registered", msgID)
-// 	}
-
-// 	i.msgRawHandler = msgRawHandler
-// }
-
-// // goroutine safe
-// func (p *Processor) Route(msg interface{}, userData interface{}) error {
-// 	// raw
-// 	if msgRaw, ok := msg.(MsgRaw); ok {
-// 		i, ok := p.msgInfo[msgRaw.msgID]
-// 		if !ok {
-// 			return fmt.Errorf("message %v not registered", msgRaw.msgID)
-// 		}
-// 		if i.msgRawHandler != nil {
-// 			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
-// 		}
-// 		return nil
-// 	}
-
-// 	// json
-// 	msgType := reflect.TypeOf(msg)
-// 	if msgType == nil || msgType.Kind() != reflect.Ptr {
-// 		return errors.New("json message pointer required")
-// 	}
-// 	msgID := msgType.Elem().Name()
-// 	i, ok := p.msgInfo[msgID]
-// 	if !ok {
-// 		return fmt.Errorf("message %v not registered", msgID)
-// 	}
-// 	if i.msgHandler != nil {
-// 		i.msgHandler([]interface{}{msg, userData})
-// 	}
-// 	if i.msgRouter != nil {
-// 		i.msgRouter.Go(msgType, msg, userData)
-// 	}
-// 	return nil
-// }
-
-// // goroutine safe
-// func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
-// 	var m map[string]json.RawMessage
-// 	err := json.Unmarshal(data, &m)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	if len(m) != 1 {
-// 		return nil, errors.New("invalid json data")
-// 	}
-
-// 	for msgID, data := range m {
-// 		i, ok := p.msgInfo[msgID]
-// 		if !ok {
-// 			return nil, fmt.Errorf("message %v not registered", msgID)
-// 		}
-
-// 		// msg
-// 		if i.msgRawHandler != nil {
-// 			return MsgRaw{msgID, data}, nil
-// 		} else {
-// 			msg := reflect.New(i.msgType.Elem()).Interface()
-// 			return msg, json.Unmarshal(data, msg)
-// 		}
-// 	}
-
-// 	panic("bug")
-// }
-
-// // goroutine safe
-// func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
-// 	msgType := reflect.TypeOf(msg)
-// 	if msgType == nil || msgType.Kind() != reflect.Ptr {
-// 		return nil, errors.New("json message pointer required")
-// 	}
-// 	msgID := msgType.Elem().Name()
-// 	if _, ok := p.msgInfo[msgID]; !ok {
-// 		return nil, fmt.Errorf("message %v not registered", msgID)
-// 	}
-
-// 	// data
-// 	m := map[string]interface{}{msgID: msg}
-// 	data, err := json.Marshal(m)
-// 	return [][]byte{data}, err
-// }
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/somethinghero/leaf/chanrpc"
+	"github.com/somethinghero/leaf/log"
+)
+
+//Envelope the format of a json message
+type Envelope int
+
+// envelopes
+const (
+	// {"MsgName": {...}}
+	NameEnvelope Envelope = iota
+	// {"id": 1, "data": {...}}
+	IDEnvelope
+)
+
+//Processor Processor
+type Processor struct {
+	envelope Envelope
+	msgInfo  map[string]*MsgInfo
+	msgID    map[uint16]*MsgInfo
+	nextID   uint16
+}
+
+//MsgInfo msg info
+type MsgInfo struct {
+	msgName       string
+	msgID         uint16
+	msgType       reflect.Type
+	msgRouter     *chanrpc.Server
+	msgHandler    MsgHandler
+	msgRawHandler MsgHandler
+}
+
+//MsgHandler msg handler
+type MsgHandler func([]interface{})
+
+//MsgRaw a message with a raw handler, routed without unmarshaling
+type MsgRaw struct {
+	msgName    string
+	msgRawData json.RawMessage
+}
+
+//MsgName implements network.RawMsg
+func (msgRaw MsgRaw) MsgName() string {
+	return msgRaw.msgName
+}
+
+type idEnvelope struct {
+	ID   *uint16         `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+//NewProcessor NewProcessor
+func NewProcessor() *Processor {
+	p := new(Processor)
+	p.msgInfo = make(map[string]*MsgInfo)
+	p.msgID = make(map[uint16]*MsgInfo)
+	return p
+}
+
+//SetEnvelope It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetEnvelope(envelope Envelope) {
+	p.envelope = envelope
+}
+
+//Register desc:
+// the id of the message is the smallest unused id not less than the
+// previous id, starting from 0
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Register(msg interface{}) string {
+	for {
+		if _, ok := p.msgID[p.nextID]; !ok {
+			break
+		}
+		p.nextID++
+	}
+	msgName, err := p.RegisterWithID(msg, p.nextID)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	return msgName
+}
+
+//RegisterWithID desc:
+// register msg with id, return an error without registering msg if msg or
+// id is already registered
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterWithID(msg interface{}, msgID uint16) (string, error) {
+	msgName := p.msgName(msg)
+	if _, ok := p.msgInfo[msgName]; ok {
+		return "", fmt.Errorf("message %v is already registered", msgName)
+	}
+	if i, ok := p.msgID[msgID]; ok {
+		return "", fmt.Errorf("message id %v is already registered by %v", msgID, i.msgName)
+	}
+
+	i := new(MsgInfo)
+	i.msgName = msgName
+	i.msgID = msgID
+	i.msgType = reflect.TypeOf(msg)
+	p.msgInfo[msgName] = i
+	p.msgID[msgID] = i
+	return msgName, nil
+}
+
+func (p *Processor) msgName(msg interface{}) string {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+	msgName := msgType.Elem().Name()
+	if msgName == "" {
+		log.Fatal("unnamed json message")
+	}
+	return msgName
+}
+
+func (p *Processor) info(msg interface{}) *MsgInfo {
+	msgName := p.msgName(msg)
+	i, ok := p.msgInfo[msgName]
+	if !ok {
+		log.Fatal("message %v not registered", msgName)
+	}
+	return i
+}
+
+//SetRouter It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
+	p.info(msg).msgRouter = msgRouter
+}
+
+//SetHandler It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
+	p.info(msg).msgHandler = msgHandler
+}
+
+//SetRawHandler desc:
+// the message is not unmarshaled, msgRawHandler is called with
+// the message name, the json.RawMessage and the agent
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRawHandler(msgName string, msgRawHandler MsgHandler) {
+	i, ok := p.msgInfo[msgName]
+	if !ok {
+		log.Fatal("message %v not registered", msgName)
+	}
+
+	i.msgRawHandler = msgRawHandler
+}
+
+//MsgID the id of a registered message
+func (p *Processor) MsgID(msg interface{}) (uint16, bool) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return 0, false
+	}
+	i, ok := p.msgInfo[msgType.Elem().Name()]
+	if !ok {
+		return 0, false
+	}
+	return i.msgID, true
+}
+
+//Route goroutine safe
+func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	// raw
+	if msgRaw, ok := msg.(MsgRaw); ok {
+		i, ok := p.msgInfo[msgRaw.msgName]
+		if !ok {
+			return fmt.Errorf("message %v not registered", msgRaw.msgName)
+		}
+		if i.msgRawHandler != nil {
+			i.msgRawHandler([]interface{}{msgRaw.msgName, msgRaw.msgRawData, userData})
+		}
+		return nil
+	}
+
+	// json
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return errors.New("json message pointer required")
+	}
+	msgName := msgType.Elem().Name()
+	i, ok := p.msgInfo[msgName]
+	if !ok {
+		return fmt.Errorf("message %v not registered", msgName)
+	}
+	if i.msgHandler != nil {
+		i.msgHandler([]interface{}{msg, userData})
+	}
+	if i.msgRouter != nil {
+		i.msgRouter.Go(msgType, msg, userData)
+	}
+	return nil
+}
+
+//Unmarshal goroutine safe
+func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	var i *MsgInfo
+	var msgData json.RawMessage
+	switch p.envelope {
+	case IDEnvelope:
+		var e idEnvelope
+		err := json.Unmarshal(data, &e)
+		if err != nil {
+			return nil, err
+		}
+		if e.ID == nil {
+			return nil, errors.New("json message id not found")
+		}
+		var ok bool
+		i, ok = p.msgID[*e.ID]
+		if !ok {
+			return nil, fmt.Errorf("message id %v not registered", *e.ID)
+		}
+		msgData = e.Data
+	default:
+		var m map[string]json.RawMessage
+		err := json.Unmarshal(data, &m)
+		if err != nil {
+			return nil, err
+		}
+		if len(m) != 1 {
+			return nil, errors.New("invalid json data")
+		}
+		for msgName, data := range m {
+			var ok bool
+			i, ok = p.msgInfo[msgName]
+			if !ok {
+				return nil, fmt.Errorf("message %v not registered", msgName)
+			}
+			msgData = data
+		}
+	}
+
+	// msg
+	if i.msgRawHandler != nil {
+		return MsgRaw{i.msgName, msgData}, nil
+	}
+	msg := reflect.New(i.msgType.Elem()).Interface()
+	if len(msgData) == 0 {
+		return msg, nil
+	}
+	return msg, json.Unmarshal(msgData, msg)
+}
+
+//Marshal goroutine safe
+func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return nil, errors.New("json message pointer required")
+	}
+	msgName := msgType.Elem().Name()
+	i, ok := p.msgInfo[msgName]
+	if !ok {
+		return nil, fmt.Errorf("message %v not registered", msgName)
+	}
+
+	// data
+	var data []byte
+	var err error
+	switch p.envelope {
+	case IDEnvelope:
+		data, err = json.Marshal(struct {
+			ID   uint16      `json:"id"`
+			Data interface{} `json:"data"`
+		}{i.msgID, msg})
+	default:
+		data, err = json.Marshal(map[string]interface{}{msgName: msg})
+	}
+	return [][]byte{data}, err
+}
+
+//Range goroutine safe
+func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
+	for id, i := range p.msgID {
+		f(id, i.msgType)
+	}
+}
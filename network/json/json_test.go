@@ -0,0 +1,50 @@
+package json_test
+
+import (
+	"testing"
+
+	"github.com/somethinghero/leaf/network/json"
+)
+
+type Chat struct {
+	Text string
+}
+
+func TestRegisterWithIDDuplicate(t *testing.T) {
+	p := json.NewProcessor()
+	p.SetEnvelope(json.IDEnvelope)
+	if _, err := p.RegisterWithID(&Hello{}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		msg  interface{}
+		id   uint16
+	}{
+		{"id", &Move{}, 1},
+		{"name", &Hello{}, 2},
+	} {
+		if _, err := p.RegisterWithID(test.msg, test.id); err == nil {
+			t.Fatalf("registered a duplicate %v", test.name)
+		}
+	}
+
+	// Move is not registered, Hello keeps its id
+	if _, err := p.Marshal(&Move{}); err == nil {
+		t.Fatal("the message of the duplicate id is registered")
+	}
+	data, err := p.Marshal(&Hello{"leaf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[0]) != `{"id":1,"data":{"Name":"leaf"}}` {
+		t.Fatalf("%s", data[0])
+	}
+
+	// the id is free to use after the failures
+	msgName, err := p.RegisterWithID(&Chat{}, 2)
+	if err != nil || msgName != "Chat" {
+		t.Fatalf("%v %v", msgName, err)
+	}
+}
@@ -0,0 +1,165 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	kcp "github.com/somethinghero/kcp-go"
+	"github.com/somethinghero/leaf/log"
+)
+
+//KCPClient kcp client
+type KCPClient struct {
+	sync.Mutex
+	Addr            string
+	ConnNum         int
+	ConnectInterval time.Duration
+	PendingWriteNum int
+	AutoReconnect   bool
+	NewAgent        func(*KCPConn) Agent
+	conns           KCPConnSet
+	wg              sync.WaitGroup
+	closeFlag       bool
+	block           kcp.BlockCrypt
+
+	// nil means DefaultKCPConfig
+	Config *KCPConfig
+
+	// close connections without messages read for IdleTimeout, 0 means no
+	// timeout, kcp sends no close so a dead server is only found this way
+	IdleTimeout time.Duration
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	msgParser    *MsgParser
+}
+
+//Start start
+func (client *KCPClient) Start() error {
+	err := client.init()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < client.ConnNum; i++ {
+		client.wg.Add(1)
+		go client.connect()
+	}
+	return nil
+}
+
+func (client *KCPClient) init() error {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.NewAgent == nil {
+		return errors.New("NewAgent must not be nil")
+	}
+	if client.conns != nil {
+		return errors.New("client is running")
+	}
+
+	if client.ConnNum <= 0 {
+		client.ConnNum = 1
+		log.Release("invalid ConnNum, reset to %v", client.ConnNum)
+	}
+	if client.ConnectInterval <= 0 {
+		client.ConnectInterval = 3 * time.Second
+		log.Release("invalid ConnectInterval, reset to %v", client.ConnectInterval)
+	}
+	if client.PendingWriteNum <= 0 {
+		client.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
+	}
+	if client.Config == nil {
+		client.Config = DefaultKCPConfig()
+	}
+	block, err := client.Config.blockCrypt()
+	if err != nil {
+		return err
+	}
+
+	client.conns = make(KCPConnSet)
+	client.closeFlag = false
+	client.block = block
+
+	// msg parser
+	msgParser := NewMsgParser()
+	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
+	msgParser.SetByteOrder(client.LittleEndian)
+	client.msgParser = msgParser
+	return nil
+}
+
+func (client *KCPClient) dial() *kcp.UDPSession {
+	for {
+		conn, err := kcp.DialWithOptions(client.Addr, client.block, client.Config.DataShard, client.Config.ParityShard)
+		if err == nil {
+			return conn
+		}
+		client.Lock()
+		closeFlag := client.closeFlag
+		client.Unlock()
+		if closeFlag {
+			return nil
+		}
+
+		log.Release("connect to %v error: %v", client.Addr, err)
+		time.Sleep(client.ConnectInterval)
+		continue
+	}
+}
+
+func (client *KCPClient) connect() {
+	defer client.wg.Done()
+
+reconnect:
+	conn := client.dial()
+	if conn == nil {
+		return
+	}
+	client.Config.apply(conn)
+
+	client.Lock()
+	if client.closeFlag {
+		client.Unlock()
+		conn.Close()
+		return
+	}
+	client.conns[conn] = struct{}{}
+	client.Unlock()
+
+	kcpConn := newKCPConn(conn, client.PendingWriteNum, client.msgParser)
+	kcpConn.idleTimeout = client.IdleTimeout
+	agent := client.NewAgent(kcpConn)
+	agent.Run()
+
+	// cleanup
+	kcpConn.Close()
+	client.Lock()
+	delete(client.conns, conn)
+	client.Unlock()
+	agent.OnClose()
+
+	if client.AutoReconnect {
+		time.Sleep(client.ConnectInterval)
+		goto reconnect
+	}
+}
+
+//Close close
+func (client *KCPClient) Close() {
+	client.Lock()
+	client.closeFlag = true
+	for conn := range client.conns {
+		conn.Close()
+	}
+	client.conns = nil
+	client.Unlock()
+
+	client.wg.Wait()
+}
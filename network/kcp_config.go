@@ -0,0 +1,118 @@
+package network
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strings"
+
+	kcp "github.com/somethinghero/kcp-go"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//KCPConfig desc:
+// tuning of kcp sessions, FEC and Crypt must be the same on both sides
+// see DefaultKCPConfig for a nil config
+type KCPConfig struct {
+	// SetNoDelay
+	NoDelay      bool
+	Interval     int
+	Resend       int
+	NoCongestion bool
+	// SetMtu, SetWindowSize, SetACKNoDelay, SetWriteDelay
+	MTU        int
+	SndWnd     int
+	RcvWnd     int
+	AckNoDelay bool
+	WriteDelay bool
+
+	// forward error correction, 0 means no FEC
+	DataShard   int
+	ParityShard int
+
+	// the block cipher: "none" or "" for no encryption, "aes", "aes-128",
+	// "aes-192", "salsa20", "blowfish", "twofish", "cast5", "3des", "tea",
+	// "xtea", "xor", "sm4"
+	// the key of the cipher is derived from CryptKey by pbkdf2 with SALT
+	Crypt    string
+	CryptKey string
+}
+
+var (
+	// SALT is use for pbkdf2 key expansion
+	SALT = "kcp-server"
+)
+
+//DefaultKCPConfig the fast mode of kcp, no FEC and no encryption
+func DefaultKCPConfig() *KCPConfig {
+	return &KCPConfig{
+		NoDelay:      true,
+		Interval:     10,
+		Resend:       2,
+		NoCongestion: true,
+		MTU:          1400,
+		SndWnd:       4096,
+		RcvWnd:       4096,
+		AckNoDelay:   true,
+		WriteDelay:   true,
+	}
+}
+
+// nil means no encryption
+func (config *KCPConfig) blockCrypt() (kcp.BlockCrypt, error) {
+	name := strings.ToLower(config.Crypt)
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	if config.CryptKey == "" {
+		return nil, errors.New("kcp CryptKey required")
+	}
+
+	pass := pbkdf2.Key([]byte(config.CryptKey), []byte(SALT), 4096, 32, sha1.New)
+	switch name {
+	case "aes":
+		return kcp.NewAESBlockCrypt(pass)
+	case "aes-128":
+		return kcp.NewAESBlockCrypt(pass[:16])
+	case "aes-192":
+		return kcp.NewAESBlockCrypt(pass[:24])
+	case "salsa20":
+		return kcp.NewSalsa20BlockCrypt(pass)
+	case "blowfish":
+		return kcp.NewBlowfishBlockCrypt(pass)
+	case "twofish":
+		return kcp.NewTwofishBlockCrypt(pass)
+	case "cast5":
+		return kcp.NewCast5BlockCrypt(pass[:16])
+	case "3des":
+		return kcp.NewTripleDESBlockCrypt(pass[:24])
+	case "tea":
+		return kcp.NewTEABlockCrypt(pass[:16])
+	case "xtea":
+		return kcp.NewXTEABlockCrypt(pass[:16])
+	case "xor":
+		return kcp.NewSimpleXORBlockCrypt(pass)
+	case "sm4":
+		return kcp.NewSM4BlockCrypt(pass[:16])
+	}
+	return nil, fmt.Errorf("unknown kcp crypt: %v", config.Crypt)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (config *KCPConfig) apply(conn *kcp.UDPSession) {
+	// msg parser reads a stream
+	conn.SetStreamMode(true)
+	conn.SetWriteDelay(config.WriteDelay)
+	conn.SetNoDelay(boolToInt(config.NoDelay), config.Interval, config.Resend, boolToInt(config.NoCongestion))
+	if config.MTU > 0 {
+		conn.SetMtu(config.MTU)
+	}
+	conn.SetWindowSize(config.SndWnd, config.RcvWnd)
+	conn.SetACKNoDelay(config.AckNoDelay)
+}
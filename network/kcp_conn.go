@@ -1,9 +1,9 @@
 package network
 
 import (
-	"errors"
 	"net"
 	"sync"
+	"time"
 
 	kcp "github.com/somethinghero/kcp-go"
 	"github.com/somethinghero/leaf/log"
@@ -19,6 +19,10 @@ type KCPConn struct {
 	writeChan chan []byte
 	closeFlag bool
 	msgParser *MsgParser
+	// 0 means no timeout
+	idleTimeout time.Duration
+	// why the connection is closed by the network layer
+	closeErr error
 }
 
 func newKCPConn(conn *kcp.UDPSession, pendingWriteNum int, msgParser *MsgParser) *KCPConn {
@@ -77,14 +81,18 @@ func (kcpConn *KCPConn) Close() {
 	kcpConn.closeFlag = true
 }
 
-func (kcpConn *KCPConn) doWrite(b []byte) {
+func (kcpConn *KCPConn) doWrite(b []byte) error {
 	if len(kcpConn.writeChan) == cap(kcpConn.writeChan) {
 		log.Debug("close conn: channel full")
+		if kcpConn.closeErr == nil {
+			kcpConn.closeErr = ErrWriteChannelFull
+		}
 		kcpConn.doDestroy()
-		return
+		return ErrWriteChannelFull
 	}
 
 	kcpConn.writeChan <- b
+	return nil
 }
 
 //Write b must not be modified by the others goroutines
@@ -92,13 +100,34 @@ func (kcpConn *KCPConn) Write(b []byte) (int, error) {
 	kcpConn.Lock()
 	defer kcpConn.Unlock()
 	if kcpConn.closeFlag || b == nil {
-		return 0, errors.New("conn closed")
+		return 0, ErrConnClosed
 	}
 
-	kcpConn.doWrite(b)
+	err := kcpConn.doWrite(b)
+	if err != nil {
+		return 0, err
+	}
 	return len(b), nil
 }
 
+// record err as the reason why the connection is closed
+func (kcpConn *KCPConn) setCloseErr(err error) {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+	if kcpConn.closeErr == nil {
+		kcpConn.closeErr = err
+	}
+}
+
+//CloseErr desc:
+// why the connection is closed by the network layer, e.g.
+// ErrWriteChannelFull or ErrShutdown, nil if it is not
+func (kcpConn *KCPConn) CloseErr() error {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+	return kcpConn.closeErr
+}
+
 //Read read
 func (kcpConn *KCPConn) Read(b []byte) (int, error) {
 	return kcpConn.conn.Read(b)
@@ -116,6 +145,9 @@ func (kcpConn *KCPConn) RemoteAddr() net.Addr {
 
 //ReadMsg read msg
 func (kcpConn *KCPConn) ReadMsg() ([]byte, error) {
+	if kcpConn.idleTimeout > 0 {
+		kcpConn.conn.SetReadDeadline(time.Now().Add(kcpConn.idleTimeout))
+	}
 	return kcpConn.msgParser.Read(kcpConn)
 }
 
@@ -1,7 +1,9 @@
 package network
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	kcp "github.com/somethinghero/kcp-go"
 	"github.com/somethinghero/leaf/log"
@@ -10,17 +12,28 @@ import (
 //KCPServer kcp protocol server
 type KCPServer struct {
 	Addr            string
-	CryptKey        string
-	DataShard       int
-	ParityShard     int
 	MaxConnNum      int
 	PendingWriteNum int
 	NewAgent        func(*KCPConn) Agent
 	ln              *kcp.Listener
-	conns           KCPConnSet
+	conns           map[*kcp.UDPSession]*KCPConn
 	mutexConns      sync.Mutex
 	wgLn            sync.WaitGroup
 	wgConns         sync.WaitGroup
+	// new sessions are rejected
+	shutdown bool
+
+	// nil means DefaultKCPConfig
+	Config *KCPConfig
+
+	// Deprecated: use Config, used only if Config is nil, a CryptKey means
+	// the xtea block cipher
+	CryptKey    string
+	DataShard   int
+	ParityShard int
+
+	// close connections without messages read for IdleTimeout, 0 means no timeout
+	IdleTimeout time.Duration
 
 	// msg parser
 	LenMsgLen    int
@@ -30,27 +43,39 @@ type KCPServer struct {
 	msgParser    *MsgParser
 }
 
-var (
-	// SALT is use for pbkdf2 key expansion
-	SALT = "kcp-server"
-)
+//Start start kcp server
+func (server *KCPServer) Start() error {
+	err := server.init()
+	if err != nil {
+		return err
+	}
 
-//Start start tcp server
-func (server *KCPServer) Start() {
-	server.init()
+	server.wgLn.Add(1)
 	go server.run()
+	return nil
 }
 
-func (server *KCPServer) init() {
-	// pass := pbkdf2.Key([]byte(server.CryptKey), []byte(SALT), 4096, 32, sha1.New)
-	// block, err := kcp.NewXTEABlockCrypt(pass[:16])
-	// if err != nil {
-	// 	log.Fatal("%v", err)
-	// }
-	//ln, err := kcp.ListenWithOptions(server.Addr, block, server.DataShard, server.ParityShard)
-	ln, err := kcp.ListenWithOptions(server.Addr, nil, 0, 0)
+func (server *KCPServer) init() error {
+	if server.NewAgent == nil {
+		return errors.New("NewAgent must not be nil")
+	}
+
+	if server.Config == nil {
+		server.Config = DefaultKCPConfig()
+		if server.CryptKey != "" {
+			server.Config.Crypt = "xtea"
+			server.Config.CryptKey = server.CryptKey
+		}
+		server.Config.DataShard = server.DataShard
+		server.Config.ParityShard = server.ParityShard
+	}
+	block, err := server.Config.blockCrypt()
 	if err != nil {
-		log.Fatal("%v", err)
+		return err
+	}
+	ln, err := kcp.ListenWithOptions(server.Addr, block, server.Config.DataShard, server.Config.ParityShard)
+	if err != nil {
+		return err
 	}
 
 	if server.MaxConnNum <= 0 {
@@ -61,65 +86,82 @@ func (server *KCPServer) init() {
 		server.PendingWriteNum = 100
 		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
 	}
-	if server.NewAgent == nil {
-		log.Fatal("NewAgent must not be nil")
-	}
 
 	server.ln = ln
-	server.conns = make(KCPConnSet)
+	server.conns = make(map[*kcp.UDPSession]*KCPConn)
 
 	// msg parser
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
 	msgParser.SetByteOrder(server.LittleEndian)
 	server.msgParser = msgParser
+	return nil
 }
 
 func (server *KCPServer) run() {
-	server.wgLn.Add(1)
 	defer server.wgLn.Done()
 
 	for {
 		conn, err := server.ln.AcceptKCP()
-		if err == nil {
-			conn.SetStreamMode(true)
-			conn.SetWriteDelay(true)
-			conn.SetNoDelay(1, 10, 2, 1)
-			conn.SetMtu(1400)
-			conn.SetWindowSize(4096, 4096)
-			conn.SetACKNoDelay(true)
+		if err != nil {
+			// the listener is closed
+			log.Debug("AcceptKCP error: %v", err)
+			return
+		}
+		server.Config.apply(conn)
 
-			server.mutexConns.Lock()
-			if len(server.conns) >= server.MaxConnNum {
-				server.mutexConns.Unlock()
-				conn.Close()
-				log.Error("too many connections")
-				continue
-			}
-			server.conns[conn] = struct{}{}
+		server.mutexConns.Lock()
+		if server.shutdown {
+			server.mutexConns.Unlock()
+			conn.Close()
+			continue
+		}
+		if len(server.conns) >= server.MaxConnNum {
 			server.mutexConns.Unlock()
+			conn.Close()
+			log.Error("too many connections")
+			continue
+		}
+		kcpConn := newKCPConn(conn, server.PendingWriteNum, server.msgParser)
+		kcpConn.idleTimeout = server.IdleTimeout
+		server.conns[conn] = kcpConn
+		server.mutexConns.Unlock()
 
-			server.wgConns.Add(1)
+		server.wgConns.Add(1)
 
-			kcpConn := newKCPConn(conn, server.PendingWriteNum, server.msgParser)
-			agent := server.NewAgent(kcpConn)
-			go func() {
-				agent.Run()
+		agent := server.NewAgent(kcpConn)
+		go func() {
+			agent.Run()
 
-				// cleanup
-				kcpConn.Close()
-				server.mutexConns.Lock()
-				delete(server.conns, conn)
-				server.mutexConns.Unlock()
-				agent.OnClose()
+			// cleanup
+			kcpConn.Close()
+			server.mutexConns.Lock()
+			delete(server.conns, conn)
+			server.mutexConns.Unlock()
+			agent.OnClose()
 
-				server.wgConns.Done()
-			}()
-		} else {
-			log.Error("AcceptKCP error:%v", err.Error())
-		}
+			server.wgConns.Done()
+		}()
+	}
+}
+
+//Shutdown desc:
+// stop accepting connections and close every connection after its pending
+// writes are flushed, the connections still open after timeout are closed
+func (server *KCPServer) Shutdown(timeout time.Duration) {
+	// the sessions write to the socket of the listener, it is closed last
+	server.mutexConns.Lock()
+	server.shutdown = true
+	for _, kcpConn := range server.conns {
+		kcpConn.setCloseErr(ErrShutdown)
+		kcpConn.Close()
+	}
+	server.mutexConns.Unlock()
 
+	if !waitTimeout(&server.wgConns, timeout) {
+		log.Release("kcp server %v: close connections after %v", server.Addr, timeout)
 	}
+	server.Close()
 }
 
 //Close close
@@ -128,7 +170,8 @@ func (server *KCPServer) Close() {
 	server.wgLn.Wait()
 
 	server.mutexConns.Lock()
-	for conn := range server.conns {
+	for conn, kcpConn := range server.conns {
+		kcpConn.setCloseErr(ErrShutdown)
 		conn.Close()
 	}
 	server.conns = nil
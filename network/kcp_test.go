@@ -0,0 +1,182 @@
+package network_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/network"
+)
+
+func freeUDPAddr(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestKCPServerStart(t *testing.T) {
+	newAgent := func(conn *network.KCPConn) network.Agent {
+		return &echoAgent{conn: conn}
+	}
+
+	// the deprecated fields
+	server := &network.KCPServer{
+		Addr:        "127.0.0.1:0",
+		NewAgent:    newAgent,
+		CryptKey:    "leaf",
+		DataShard:   10,
+		ParityShard: 3,
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if c := server.Config; c.Crypt != "xtea" || c.CryptKey != "leaf" || c.DataShard != 10 || c.ParityShard != 3 {
+		t.Fatalf("%+v", c)
+	}
+
+	for _, server := range []*network.KCPServer{
+		{Addr: "127.0.0.1:0", NewAgent: newAgent, Config: &network.KCPConfig{Crypt: "rot13", CryptKey: "leaf"}},
+		{Addr: "127.0.0.1:0", NewAgent: newAgent, Config: &network.KCPConfig{Crypt: "aes"}},
+		{Addr: "127.0.0.1:0"},
+	} {
+		if err := server.Start(); err == nil {
+			server.Close()
+			t.Fatalf("%+v: started", server.Config)
+		}
+	}
+}
+
+func startKCPServer(t *testing.T, addr string) *network.KCPServer {
+	server := &network.KCPServer{
+		Addr:            addr,
+		MaxConnNum:      10,
+		PendingWriteNum: 10,
+		LenMsgLen:       2,
+		MaxMsgLen:       4096,
+		NewAgent: func(conn *network.KCPConn) network.Agent {
+			return &echoAgent{conn: conn}
+		},
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestKCPClient(t *testing.T) {
+	server := startKCPServer(t, freeUDPAddr(t))
+	defer server.Close()
+
+	result := make(chan error, 1)
+	client := &network.KCPClient{
+		Addr:            server.Addr,
+		PendingWriteNum: 10,
+		LenMsgLen:       2,
+		MaxMsgLen:       4096,
+		NewAgent: func(conn *network.KCPConn) network.Agent {
+			return &helloAgent{conn: conn, result: result}
+		},
+	}
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no echo")
+	}
+}
+
+// says hello every interval and sends the number of its connection on echo
+type reconnectAgent struct {
+	conn  network.Conn
+	n     int
+	echos chan int
+}
+
+func (a *reconnectAgent) Run() {
+	for {
+		if err := a.conn.WriteMsg([]byte("hello")); err != nil {
+			return
+		}
+		if _, err := a.conn.ReadMsg(); err != nil {
+			return
+		}
+		select {
+		case a.echos <- a.n:
+		default:
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (a *reconnectAgent) OnClose() {}
+
+// kcp sends no close, the client finds the server gone by IdleTimeout
+func TestKCPClientReconnect(t *testing.T) {
+	addr := freeUDPAddr(t)
+	server := startKCPServer(t, addr)
+
+	var mutex sync.Mutex
+	conns := 0
+	echos := make(chan int, 1)
+	client := &network.KCPClient{
+		Addr:            addr,
+		ConnectInterval: 50 * time.Millisecond,
+		PendingWriteNum: 10,
+		AutoReconnect:   true,
+		IdleTimeout:     300 * time.Millisecond,
+		LenMsgLen:       2,
+		MaxMsgLen:       4096,
+		NewAgent: func(conn *network.KCPConn) network.Agent {
+			mutex.Lock()
+			defer mutex.Unlock()
+			conns++
+			return &reconnectAgent{conn: conn, n: conns, echos: echos}
+		},
+	}
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	waitEcho := func(min int) {
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case n := <-echos:
+				if n >= min {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("no echo on connection %v", min)
+			}
+		}
+	}
+	waitEcho(1)
+
+	// the connection is kept while the server answers
+	time.Sleep(500 * time.Millisecond)
+	mutex.Lock()
+	n := conns
+	mutex.Unlock()
+	if n != 1 {
+		t.Fatalf("%v connections", n)
+	}
+
+	server.Close()
+	time.Sleep(100 * time.Millisecond)
+	server = startKCPServer(t, addr)
+	defer server.Close()
+	waitEcho(2)
+}
@@ -9,6 +9,12 @@ import (
 	//"runtime/debug"
 )
 
+// errors of message length
+var (
+	ErrMsgTooLong  = errors.New("message too long")
+	ErrMsgTooShort = errors.New("message too short")
+)
+
 //MsgParser format:
 // --------------
 // | len | data |
@@ -65,16 +71,7 @@ func (p *MsgParser) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
-//Read goroutine safe
-func (p *MsgParser) Read(conn io.Reader) ([]byte, error) {
-	var b [4]byte
-	bufMsgLen := b[:p.lenMsgLen]
-	//debug.PrintStack()
-	// read len
-	if _, err := io.ReadFull(conn, bufMsgLen); err != nil {
-		return nil, err
-	}
-	// parse len
+func (p *MsgParser) parseLen(bufMsgLen []byte) (uint32, error) {
 	var msgLen uint32
 	switch p.lenMsgLen {
 	case 1:
@@ -95,9 +92,26 @@ func (p *MsgParser) Read(conn io.Reader) ([]byte, error) {
 
 	// check len
 	if msgLen > p.maxMsgLen {
-		return nil, errors.New("message too long")
+		return 0, ErrMsgTooLong
 	} else if msgLen < p.minMsgLen {
-		return nil, errors.New("message too short")
+		return 0, ErrMsgTooShort
+	}
+	return msgLen, nil
+}
+
+//Read goroutine safe
+func (p *MsgParser) Read(conn io.Reader) ([]byte, error) {
+	var b [4]byte
+	bufMsgLen := b[:p.lenMsgLen]
+	//debug.PrintStack()
+	// read len
+	if _, err := io.ReadFull(conn, bufMsgLen); err != nil {
+		return nil, err
+	}
+	// parse len
+	msgLen, err := p.parseLen(bufMsgLen)
+	if err != nil {
+		return nil, err
 	}
 
 	// data
@@ -108,6 +122,27 @@ func (p *MsgParser) Read(conn io.Reader) ([]byte, error) {
 	return msgData, nil
 }
 
+//Unpack desc:
+// the data of a packed message, b is exactly one message
+// ErrMsgTooShort or ErrMsgTooLong if b is shorter or longer than the message
+// goroutine safe
+func (p *MsgParser) Unpack(b []byte) ([]byte, error) {
+	if len(b) < p.lenMsgLen {
+		return nil, ErrMsgTooShort
+	}
+	msgLen, err := p.parseLen(b[:p.lenMsgLen])
+	if err != nil {
+		return nil, err
+	}
+	data := b[p.lenMsgLen:]
+	if uint32(len(data)) < msgLen {
+		return nil, ErrMsgTooShort
+	} else if uint32(len(data)) > msgLen {
+		return nil, ErrMsgTooLong
+	}
+	return data, nil
+}
+
 //Write goroutine safe
 func (p *MsgParser) Write(conn io.Writer, args ...[]byte) error {
 	// get len
@@ -118,9 +153,9 @@ func (p *MsgParser) Write(conn io.Writer, args ...[]byte) error {
 
 	// check len
 	if msgLen > p.maxMsgLen {
-		return errors.New("message too long")
+		return ErrMsgTooLong
 	} else if msgLen < p.minMsgLen {
-		return errors.New("message too short")
+		return ErrMsgTooShort
 	}
 
 	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
@@ -150,7 +185,6 @@ func (p *MsgParser) Write(conn io.Writer, args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	conn.Write(msg)
-
-	return nil
+	_, err := conn.Write(msg)
+	return err
 }
@@ -9,3 +9,10 @@ type Processor interface {
 	// must goroutine safe
 	Marshal(msg interface{}) ([][]byte, error)
 }
+
+//RawMsg a message unmarshaled without decoding its body, e.g. the MsgRaw
+// of a processor
+type RawMsg interface {
+	// the registered name of the message
+	MsgName() string
+}
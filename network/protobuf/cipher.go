@@ -0,0 +1,101 @@
+package protobuf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/somethinghero/xxtea-go/xxtea"
+)
+
+//Cipher desc:
+// encrypt and decrypt message bodies
+// must goroutine safe
+type Cipher interface {
+	Encrypt(data []byte) ([]byte, error)
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// the xxtea key of the versions before pluggable ciphers
+const legacyCryptKey = "skyyyloveyyforeverforever"
+
+//NewCipher desc:
+// name: "xxtea" or "" (default), "aes-gcm", "none" for no encryption
+// an empty key of xxtea means the key of the earlier versions, so that the
+// default is compatible with their clients
+// the key of aes-gcm must be 16, 24 or 32 bytes
+func NewCipher(name string, key []byte) (Cipher, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return nil, nil
+	case "", "xxtea":
+		if len(key) == 0 {
+			key = []byte(legacyCryptKey)
+		}
+		return NewXXTEACipher(key)
+	case "aes-gcm", "aesgcm":
+		return NewAESGCMCipher(key)
+	}
+	return nil, fmt.Errorf("unknown cipher: %v", name)
+}
+
+type xxteaCipher struct {
+	key []byte
+}
+
+//NewXXTEACipher NewXXTEACipher
+func NewXXTEACipher(key []byte) (Cipher, error) {
+	if len(key) == 0 {
+		return nil, errors.New("xxtea key required")
+	}
+	return &xxteaCipher{key: key}, nil
+}
+
+func (c *xxteaCipher) Encrypt(data []byte) ([]byte, error) {
+	return xxtea.EncryptExt(data, c.key), nil
+}
+
+func (c *xxteaCipher) Decrypt(data []byte) ([]byte, error) {
+	return xxtea.DecryptExt(data, c.key)
+}
+
+//aesGCMCipher formate:
+// ----------------------------
+// | nonce | ciphertext | tag |
+// ----------------------------
+type aesGCMCipher struct {
+	aead cipher.AEAD
+}
+
+//NewAESGCMCipher NewAESGCMCipher
+func NewAESGCMCipher(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCMCipher{aead: aead}, nil
+}
+
+func (c *aesGCMCipher) Encrypt(data []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	buf := make([]byte, nonceSize, nonceSize+len(data)+c.aead.Overhead())
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(buf, buf, data, nil), nil
+}
+
+func (c *aesGCMCipher) Decrypt(data []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize+c.aead.Overhead() {
+		return nil, errors.New("aes-gcm data too short")
+	}
+	return c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
@@ -0,0 +1,138 @@
+package protobuf_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/somethinghero/leaf/network/protobuf"
+)
+
+func TestCipherRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"", ""},
+		{"xxtea", "0123456789"},
+		{"aes-gcm", "0123456789abcdef"},
+		{"aes-gcm", "0123456789abcdef01234567"},
+		{"aes-gcm", "0123456789abcdef0123456789abcdef"},
+	}
+
+	data := []byte("hello leaf")
+	for _, test := range tests {
+		cipher, err := protobuf.NewCipher(test.name, []byte(test.key))
+		if err != nil {
+			t.Fatalf("%q: %v", test.name, err)
+		}
+		encrypted, err := cipher.Encrypt(data)
+		if err != nil {
+			t.Fatalf("%q: %v", test.name, err)
+		}
+		if bytes.Equal(encrypted, data) {
+			t.Fatalf("%q: data is not encrypted", test.name)
+		}
+		decrypted, err := cipher.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("%q: %v", test.name, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%q: %q, want %q", test.name, decrypted, data)
+		}
+	}
+}
+
+func TestCipherWrongKey(t *testing.T) {
+	data := []byte("hello leaf")
+
+	c1, _ := protobuf.NewCipher("aes-gcm", []byte("0123456789abcdef"))
+	c2, _ := protobuf.NewCipher("aes-gcm", []byte("fedcba9876543210"))
+	encrypted, err := c1.Encrypt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Decrypt(encrypted); err == nil {
+		t.Fatal("aes-gcm: decrypted with a wrong key")
+	}
+	if _, err := c1.Decrypt(encrypted[:len(encrypted)-1]); err == nil {
+		t.Fatal("aes-gcm: decrypted truncated data")
+	}
+
+	c1, _ = protobuf.NewCipher("xxtea", []byte("0123456789"))
+	c2, _ = protobuf.NewCipher("xxtea", []byte("9876543210"))
+	encrypted, _ = c1.Encrypt(data)
+	decrypted, err := c2.Decrypt(encrypted)
+	if err == nil && bytes.Equal(decrypted, data) {
+		t.Fatal("xxtea: decrypted with a wrong key")
+	}
+}
+
+func TestNewCipherInvalid(t *testing.T) {
+	if _, err := protobuf.NewCipher("rot13", nil); err == nil {
+		t.Fatal("unknown cipher")
+	}
+	if _, err := protobuf.NewCipher("aes-gcm", []byte("short")); err == nil {
+		t.Fatal("invalid aes key")
+	}
+	if c, err := protobuf.NewCipher("none", nil); c != nil || err != nil {
+		t.Fatalf("none: %v, %v", c, err)
+	}
+}
+
+// the default cipher is compatible with the earlier versions
+func TestCipherDefault(t *testing.T) {
+	legacy, _ := protobuf.NewXXTEACipher([]byte("skyyyloveyyforeverforever"))
+	def, err := protobuf.NewCipher("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello leaf")
+	encrypted, _ := legacy.Encrypt(data)
+	decrypted, err := def.Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("%q, %v", decrypted, err)
+	}
+}
+
+func TestProcessorCipher(t *testing.T) {
+	newProcessor := func() *protobuf.Processor {
+		p := protobuf.NewProcessor()
+		p.Register(&wrappers.StringValue{})
+		return p
+	}
+
+	p1 := newProcessor()
+	if err := p1.SetCipherByName("aes-gcm", []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := p1.Marshal(&wrappers.StringValue{Value: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := p1.Unmarshal(bytes.Join(data, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := msg.(*wrappers.StringValue).Value; v != "hello" {
+		t.Fatalf("%q", v)
+	}
+
+	// wrong key
+	p2 := newProcessor()
+	p2.SetCipherByName("aes-gcm", []byte("fedcba9876543210"))
+	if _, err := p2.Unmarshal(bytes.Join(data, nil)); err == nil {
+		t.Fatal("unmarshaled with a wrong key")
+	}
+
+	// invalid
+	p3 := newProcessor()
+	if err := p3.SetCipherByName("aes-gcm", []byte("short")); err == nil {
+		t.Fatal("invalid aes key")
+	}
+
+	// late
+	if err := p1.SetCipher(nil); err != protobuf.ErrCipherInUse {
+		t.Fatalf("late SetCipher: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//IDMode how a message is identified on the wire
+type IDMode int
+
+// id modes
+const (
+	// | namelen(2 bytes) | name |
+	NameID IDMode = iota
+	// | id(2 bytes) |
+	Uint16ID
+	// | id(4 bytes) |
+	Uint32ID
+)
+
+//ManifestEntry an entry of the message id table
+type ManifestEntry struct {
+	ID   uint32 `json:"id"`
+	Name string `json:"name"`
+}
+
+//SetIDMode desc:
+// in Uint16ID or Uint32ID mode every message must have an id, given by
+// RegisterWithID or LoadManifest
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetIDMode(mode IDMode) {
+	p.idMode = mode
+}
+
+//RegisterWithID desc:
+// register msg with id, return an error without registering msg if id is
+// used by another message
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterWithID(msg proto.Message, id uint32) (string, error) {
+	if other, ok := p.msgID[id]; ok {
+		return "", fmt.Errorf("message id %v is already registered by %v", id, other.msgName)
+	}
+	msgName := p.Register(msg)
+	return msgName, p.setID(msgName, id)
+}
+
+func (p *Processor) setID(msgName string, id uint32) error {
+	i, ok := p.msgInfo[msgName]
+	if !ok {
+		return fmt.Errorf("message %v not registered", msgName)
+	}
+	if other, ok := p.msgID[id]; ok && other != i {
+		return fmt.Errorf("message id %v is already registered by %v", id, other.msgName)
+	}
+	if i.hasID {
+		delete(p.msgID, i.msgID)
+	}
+	i.msgID = id
+	i.hasID = true
+	p.msgID[id] = i
+	return nil
+}
+
+//LoadManifest desc:
+// give ids to registered messages, data is a json array of ManifestEntry,
+// the output of ExportManifest
+// the whole manifest is checked first, no id is changed if it returns an error
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) LoadManifest(data []byte) error {
+	var entries []ManifestEntry
+	err := json.Unmarshal(data, &entries)
+	if err != nil {
+		return err
+	}
+
+	infos := make([]*MsgInfo, len(entries))
+	names := make(map[string]bool, len(entries))
+	ids := make(map[uint32]string, len(entries))
+	for n, e := range entries {
+		i, ok := p.msgInfo[e.Name]
+		if !ok {
+			return fmt.Errorf("message %v not registered", e.Name)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("message %v is listed twice", e.Name)
+		}
+		if other, ok := ids[e.ID]; ok {
+			return fmt.Errorf("message id %v is listed for %v and %v", e.ID, other, e.Name)
+		}
+		infos[n] = i
+		names[e.Name] = true
+		ids[e.ID] = e.Name
+	}
+	// the ids of the listed messages are given again
+	for _, e := range entries {
+		if other, ok := p.msgID[e.ID]; ok && !names[other.msgName] {
+			return fmt.Errorf("message id %v is already registered by %v", e.ID, other.msgName)
+		}
+	}
+
+	for _, i := range infos {
+		if i.hasID {
+			delete(p.msgID, i.msgID)
+		}
+	}
+	for n, e := range entries {
+		i := infos[n]
+		i.msgID = e.ID
+		i.hasID = true
+		p.msgID[e.ID] = i
+	}
+	return nil
+}
+
+//ExportManifest the message id table sorted by id, as a json array of ManifestEntry
+func (p *Processor) ExportManifest() ([]byte, error) {
+	entries := []ManifestEntry{}
+	p.Range(func(id uint32, name string, t reflect.Type) {
+		entries = append(entries, ManifestEntry{ID: id, Name: name})
+	})
+	return json.MarshalIndent(entries, "", "\t")
+}
+
+//Range desc:
+// call f for every message with an id, in order of id
+// goroutine safe
+func (p *Processor) Range(f func(id uint32, name string, t reflect.Type)) {
+	ids := make([]uint32, 0, len(p.msgID))
+	for id := range p.msgID {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids {
+		i := p.msgID[id]
+		f(id, i.msgName, i.msgType)
+	}
+}
+
+func (p *Processor) byteOrder() binary.ByteOrder {
+	if p.littleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// returns the message and the rest of data
+func (p *Processor) readID(data []byte) (*MsgInfo, []byte, error) {
+	switch p.idMode {
+	case Uint16ID:
+		if len(data) < 2 {
+			return nil, nil, errors.New("protobuf data too short")
+		}
+		id := uint32(p.byteOrder().Uint16(data))
+		i, ok := p.msgID[id]
+		if !ok {
+			return nil, nil, fmt.Errorf("message id %v not registered", id)
+		}
+		return i, data[2:], nil
+	case Uint32ID:
+		if len(data) < 4 {
+			return nil, nil, errors.New("protobuf data too short")
+		}
+		id := p.byteOrder().Uint32(data)
+		i, ok := p.msgID[id]
+		if !ok {
+			return nil, nil, fmt.Errorf("message id %v not registered", id)
+		}
+		return i, data[4:], nil
+	}
+
+	if len(data) < 2 {
+		return nil, nil, errors.New("protobuf data too short 1")
+	}
+
+	// namelen
+	namelen := p.byteOrder().Uint16(data)
+	if namelen <= 0 {
+		return nil, nil, errors.New("protobuf namelen too short")
+	}
+	if len(data) < (2 + int(namelen)) {
+		return nil, nil, errors.New("protobuf data too short 2")
+	}
+	//name
+	name := string(data[2 : 2+namelen])
+	i, ok := p.msgInfo[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("message name %v not registered", name)
+	}
+	return i, data[2+namelen:], nil
+}
+
+func (p *Processor) writeID(msgName string) ([][]byte, error) {
+	if p.idMode == NameID {
+		bufNamelen := make([]byte, 2)
+		p.byteOrder().PutUint16(bufNamelen, uint16(len(msgName)))
+		return [][]byte{bufNamelen, []byte(msgName)}, nil
+	}
+
+	i, ok := p.msgInfo[msgName]
+	if !ok {
+		return nil, fmt.Errorf("message %v not registered", msgName)
+	}
+	if !i.hasID {
+		return nil, fmt.Errorf("message %v has no id", msgName)
+	}
+
+	var bufID []byte
+	switch p.idMode {
+	case Uint16ID:
+		if i.msgID > math.MaxUint16 {
+			return nil, fmt.Errorf("message id %v of %v is too large", i.msgID, msgName)
+		}
+		bufID = make([]byte, 2)
+		p.byteOrder().PutUint16(bufID, uint16(i.msgID))
+	default:
+		bufID = make([]byte, 4)
+		p.byteOrder().PutUint32(bufID, i.msgID)
+	}
+	return [][]byte{bufID}, nil
+}
@@ -0,0 +1,225 @@
+package protobuf_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/somethinghero/leaf/network/protobuf"
+)
+
+func TestIDModes(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         protobuf.IDMode
+		littleEndian bool
+		// the id of StringValue on the wire
+		header []byte
+	}{
+		{"name", protobuf.NameID, false, append([]byte{0, 27}, "google.protobuf.StringValue"...)},
+		{"name little endian", protobuf.NameID, true, append([]byte{27, 0}, "google.protobuf.StringValue"...)},
+		{"uint16", protobuf.Uint16ID, false, []byte{0x01, 0x02}},
+		{"uint16 little endian", protobuf.Uint16ID, true, []byte{0x02, 0x01}},
+		{"uint32", protobuf.Uint32ID, false, []byte{0, 0, 0x01, 0x02}},
+		{"uint32 little endian", protobuf.Uint32ID, true, []byte{0x02, 0x01, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := protobuf.NewProcessor()
+			p.SetIDMode(test.mode)
+			p.SetByteOrder(test.littleEndian)
+			p.SetCipher(nil)
+			if _, err := p.RegisterWithID(&wrappers.StringValue{}, 0x0102); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.RegisterWithID(&wrappers.Int32Value{}, 3); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, msg := range []proto.Message{
+				&wrappers.StringValue{Value: "hello"},
+				&wrappers.Int32Value{Value: 42},
+			} {
+				data, err := p.Marshal(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				joined := bytes.Join(data, nil)
+				if _, ok := msg.(*wrappers.StringValue); ok && !bytes.HasPrefix(joined, test.header) {
+					t.Fatalf("% x, want the header % x", joined, test.header)
+				}
+
+				got, err := p.Unmarshal(joined)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !proto.Equal(got.(proto.Message), msg) {
+					t.Fatalf("%v, want %v", got, msg)
+				}
+			}
+
+			// unknown id or name
+			if _, err := p.Unmarshal([]byte{0, 9, 0, 9}); err == nil {
+				t.Fatal("unmarshaled an unknown message")
+			}
+			// too short
+			if _, err := p.Unmarshal([]byte{1}); err == nil {
+				t.Fatal("unmarshaled a short message")
+			}
+		})
+	}
+}
+
+func TestIDModeWithoutID(t *testing.T) {
+	p := protobuf.NewProcessor()
+	p.SetIDMode(protobuf.Uint16ID)
+	p.Register(&wrappers.StringValue{})
+	if _, err := p.Marshal(&wrappers.StringValue{}); err == nil {
+		t.Fatal("marshaled a message without id")
+	}
+
+	p = protobuf.NewProcessor()
+	p.SetIDMode(protobuf.Uint16ID)
+	p.RegisterWithID(&wrappers.StringValue{}, 0x10000)
+	if _, err := p.Marshal(&wrappers.StringValue{}); err == nil {
+		t.Fatal("marshaled a message with a uint32 id")
+	}
+}
+
+func TestRegisterWithIDDuplicate(t *testing.T) {
+	p := protobuf.NewProcessor()
+	p.SetIDMode(protobuf.Uint16ID)
+	if _, err := p.RegisterWithID(&wrappers.StringValue{}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RegisterWithID(&wrappers.Int32Value{}, 1); err == nil {
+		t.Fatal("registered a duplicate id")
+	}
+	// not registered
+	if _, err := p.Marshal(&wrappers.Int32Value{}); err == nil {
+		t.Fatal("the message of the duplicate id is registered")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	p1 := protobuf.NewProcessor()
+	p1.RegisterWithID(&wrappers.StringValue{}, 1)
+	p1.RegisterWithID(&wrappers.Int32Value{}, 7)
+	p1.RegisterWithID(&wrappers.BoolValue{}, 3)
+	p1.SetIDMode(protobuf.Uint16ID)
+	manifest, err := p1.ExportManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p2 := protobuf.NewProcessor()
+	p2.Register(&wrappers.StringValue{})
+	p2.Register(&wrappers.Int32Value{})
+	p2.Register(&wrappers.BoolValue{})
+	p2.SetIDMode(protobuf.Uint16ID)
+	if err := p2.LoadManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same table in order of id
+	exported, err := p2.ExportManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported, manifest) {
+		t.Fatalf("%s, want %s", exported, manifest)
+	}
+	var ids []uint32
+	p2.Range(func(id uint32, name string, _ reflect.Type) {
+		ids = append(ids, id)
+	})
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 3 || ids[2] != 7 {
+		t.Fatalf("ids: %v", ids)
+	}
+
+	data, err := p1.Marshal(&wrappers.Int32Value{Value: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := p2.Unmarshal(bytes.Join(data, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := msg.(*wrappers.Int32Value).Value; v != 42 {
+		t.Fatalf("%v", v)
+	}
+
+	// errors
+	p3 := protobuf.NewProcessor()
+	p3.Register(&wrappers.StringValue{})
+	if err := p3.LoadManifest(manifest); err == nil {
+		t.Fatal("loaded a manifest of unregistered messages")
+	}
+	if err := p3.LoadManifest([]byte("{")); err == nil {
+		t.Fatal("loaded an invalid manifest")
+	}
+}
+
+// a failed load changes no id
+func TestLoadManifestInvalid(t *testing.T) {
+	p := protobuf.NewProcessor()
+	p.RegisterWithID(&wrappers.StringValue{}, 1)
+	p.RegisterWithID(&wrappers.Int32Value{}, 2)
+	p.RegisterWithID(&wrappers.UInt32Value{}, 5)
+	p.Register(&wrappers.BoolValue{})
+	before, err := p.ExportManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		manifest string
+	}{
+		{"unregistered", `[{"id": 3, "name": "google.protobuf.BoolValue"}, {"id": 4, "name": "Unknown"}]`},
+		{"name twice", `[{"id": 3, "name": "google.protobuf.BoolValue"}, {"id": 4, "name": "google.protobuf.BoolValue"}]`},
+		{"id twice", `[{"id": 3, "name": "google.protobuf.BoolValue"}, {"id": 3, "name": "google.protobuf.StringValue"}]`},
+		{"id in use", `[{"id": 3, "name": "google.protobuf.BoolValue"}, {"id": 5, "name": "google.protobuf.StringValue"}]`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := p.LoadManifest([]byte(test.manifest)); err == nil {
+				t.Fatal("loaded")
+			}
+			after, err := p.ExportManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(after, before) {
+				t.Fatalf("%s, want %s", after, before)
+			}
+		})
+	}
+}
+
+// the listed messages may swap their ids
+func TestLoadManifestSwap(t *testing.T) {
+	p := protobuf.NewProcessor()
+	p.RegisterWithID(&wrappers.StringValue{}, 1)
+	p.RegisterWithID(&wrappers.Int32Value{}, 2)
+	p.RegisterWithID(&wrappers.UInt32Value{}, 5)
+	err := p.LoadManifest([]byte(`[
+		{"id": 2, "name": "google.protobuf.StringValue"},
+		{"id": 1, "name": "google.protobuf.Int32Value"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var table []string
+	p.Range(func(id uint32, name string, _ reflect.Type) {
+		table = append(table, fmt.Sprintf("%v %v", id, name))
+	})
+	want := []string{"1 google.protobuf.Int32Value", "2 google.protobuf.StringValue", "5 google.protobuf.UInt32Value"}
+	if !reflect.DeepEqual(table, want) {
+		t.Fatalf("%v, want %v", table, want)
+	}
+}
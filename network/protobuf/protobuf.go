@@ -2,34 +2,42 @@ package protobuf
 
 import (
 	//"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/somethinghero/leaf/chanrpc"
+	"github.com/somethinghero/leaf/conf"
 	"github.com/somethinghero/leaf/log"
-	"github.com/somethinghero/xxtea-go/xxtea"
 	//"math"
 	"reflect"
-)
-
-var (
-	cryptKey = "skyyyloveyyforeverforever"
-	keybuf   = []byte(cryptKey)
+	"sync"
+	"sync/atomic"
 )
 
 //Processor formate:
 // -------------------------
 // | id | protobuf message |
 // -------------------------
+// id is | namelen(2 bytes) | name | by default, see SetIDMode
+// the protobuf message is encrypted by the cipher of the processor
 type Processor struct {
 	littleEndian bool
+	idMode       IDMode
 	msgInfo      map[string]*MsgInfo
+	msgID        map[uint32]*MsgInfo
+	cipher       Cipher
+	cipherErr    error
+	cipherSet    bool
+	cipherOnce   sync.Once
+	cipherUsed   int32
 }
 
 //MsgInfo msg info
 type MsgInfo struct {
+	msgName       string
+	msgID         uint32
+	hasID         bool
 	msgType       reflect.Type
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
@@ -39,16 +47,25 @@ type MsgInfo struct {
 //MsgHandler msg handler
 type MsgHandler func([]interface{})
 
-// type MsgRaw struct {
-// 	msgID      uint16
-// 	msgRawData []byte
-// }
+//MsgRaw desc:
+// a message with a raw handler, Data is the protobuf encoded body
+// the processor decrypts Data on unmarshaling and encrypts it on marshaling
+type MsgRaw struct {
+	Name string
+	Data []byte
+}
+
+//MsgName implements network.RawMsg
+func (msgRaw MsgRaw) MsgName() string {
+	return msgRaw.Name
+}
 
 //NewProcessor new processor
 func NewProcessor() *Processor {
 	p := new(Processor)
 	p.littleEndian = false
 	p.msgInfo = make(map[string]*MsgInfo)
+	p.msgID = make(map[uint32]*MsgInfo)
 	return p
 }
 
@@ -57,6 +74,50 @@ func (p *Processor) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+//ErrCipherInUse SetCipher is called after the processor marshals or unmarshals a message
+var ErrCipherInUse = errors.New("cipher is already in use")
+
+//SetCipher desc:
+// nil means no encryption
+// if the method is not called, the cipher is created from conf.MsgCipher
+// and conf.MsgCryptKey on first use, Marshal and Unmarshal return an error
+// if they are invalid, call SetCipherByName to check them on startup
+// return ErrCipherInUse if the processor is used
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetCipher(cipher Cipher) error {
+	if atomic.LoadInt32(&p.cipherUsed) != 0 {
+		return ErrCipherInUse
+	}
+	p.cipher = cipher
+	p.cipherSet = true
+	return nil
+}
+
+//SetCipherByName desc:
+// set the cipher created by NewCipher
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetCipherByName(name string, key []byte) error {
+	cipher, err := NewCipher(name, key)
+	if err != nil {
+		return err
+	}
+	return p.SetCipher(cipher)
+}
+
+func (p *Processor) getCipher() (Cipher, error) {
+	p.cipherOnce.Do(func() {
+		atomic.StoreInt32(&p.cipherUsed, 1)
+		if p.cipherSet {
+			return
+		}
+		p.cipher, p.cipherErr = NewCipher(conf.MsgCipher, []byte(conf.MsgCryptKey))
+		if p.cipherErr != nil {
+			log.Error("protobuf cipher: %v", p.cipherErr)
+		}
+	})
+	return p.cipher, p.cipherErr
+}
+
 //Register It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) Register(msg proto.Message) string {
 	msgType := reflect.TypeOf(msg)
@@ -68,6 +129,7 @@ func (p *Processor) Register(msg proto.Message) string {
 		log.Fatal("message %s is already registered", msgType)
 	}
 	i := new(MsgInfo)
+	i.msgName = msgName
 	i.msgType = msgType
 	p.msgInfo[msgName] = i
 	return msgName
@@ -95,33 +157,32 @@ func (p *Processor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
 	p.msgInfo[msgName].msgHandler = msgHandler
 }
 
+//SetRawHandler desc:
+// the message is not decoded, msgRawHandler is called with
+// the message name, the protobuf encoded body and the agent
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-// func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
-// 	// if id >= uint16(len(p.msgInfo)) {
-// 	// 	log.Fatal("message id %v not registered", id)
-// 	// }
-// 	if _, ok := p.msgInfo[id]; !ok {
-// 		log.Fatal("message id %v not registered", id)
-// 	}
-// 	p.msgInfo[id].msgRawHandler = msgRawHandler
-// }
+func (p *Processor) SetRawHandler(msgName string, msgRawHandler MsgHandler) {
+	i, ok := p.msgInfo[msgName]
+	if !ok {
+		log.Fatal("message %v not registered", msgName)
+	}
+
+	i.msgRawHandler = msgRawHandler
+}
 
 //Route goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	// raw
-	// if msgRaw, ok := msg.(MsgRaw); ok {
-	// 	// if msgRaw.msgID >= uint16(len(p.msgInfo)) {
-	// 	// 	return fmt.Errorf("message id %v not registered", msgRaw.msgID)
-	// 	// }
-	// 	if _, ok := p.msgInfo[msgRaw.msgID]; !ok {
-	// 		return fmt.Errorf("message id %v not registered", msgRaw.msgID)
-	// 	}
-	// 	i := p.msgInfo[msgRaw.msgID]
-	// 	if i.msgRawHandler != nil {
-	// 		i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
-	// 	}
-	// 	return nil
-	// }
+	if msgRaw, ok := msg.(MsgRaw); ok {
+		i, ok := p.msgInfo[msgRaw.Name]
+		if !ok {
+			return fmt.Errorf("message %v not registered", msgRaw.Name)
+		}
+		if i.msgRawHandler != nil {
+			i.msgRawHandler([]interface{}{msgRaw.Name, msgRaw.Data, userData})
+		}
+		return nil
+	}
 
 	// protobuf
 	//msgType := reflect.TypeOf(msg)
@@ -145,70 +206,73 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 
 //Unmarshal goroutine safe
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
-	if len(data) < 2 {
-		return nil, errors.New("protobuf data too short 1")
-	}
-
-	// namelen
-	var namelen uint16
-	if p.littleEndian {
-		namelen = binary.LittleEndian.Uint16(data)
-	} else {
-		namelen = binary.BigEndian.Uint16(data)
+	i, body, err := p.readID(data)
+	if err != nil {
+		return nil, err
 	}
-	if namelen <= 0 {
-		return nil, errors.New("protobuf namelen too short")
+	//decrypt
+	cipher, err := p.getCipher()
+	if err != nil {
+		return nil, err
 	}
-	if len(data) < (2 + int(namelen)) {
-		return nil, errors.New("protobuf data too short 2")
-	}
-	//name
-	name := string(data[2 : 2+namelen])
-	// msg
-	i, ok := p.msgInfo[name]
-	if !ok {
-		return nil, fmt.Errorf("message name %v not registered", name)
+	if cipher != nil {
+		body, err = cipher.Decrypt(body)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt message %v error: %v", i.msgName, err)
+		}
 	}
 	if i.msgRawHandler != nil {
-		return nil, nil
+		return MsgRaw{i.msgName, body}, nil
 	}
 	msg := reflect.New(i.msgType.Elem()).Interface()
-	//decrypt
-	decryptdata, _ := xxtea.DecryptExt(data[2+namelen:], keybuf)
-	return msg, proto.UnmarshalMerge(decryptdata, msg.(proto.Message))
+	return msg, proto.UnmarshalMerge(body, msg.(proto.Message))
 }
 
-//Marshal goroutine safe
+//Marshal desc:
+// msg is a proto.Message, MsgRaw or *MsgRaw, the name of MsgRaw must be
+// registered
+// goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
-	//msgType := reflect.TypeOf(msg)
-
-	protoMsg, ok := msg.(proto.Message)
-	if !ok {
+	var msgName string
+	var data []byte
+	var err error
+	switch msg := msg.(type) {
+	case MsgRaw:
+		msgName = msg.Name
+		data = msg.Data
+	case *MsgRaw:
+		msgName = msg.Name
+		data = msg.Data
+	case proto.Message:
+		msgName = proto.MessageName(msg)
+		data, err = proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+	default:
 		return nil, fmt.Errorf("only surport proto msg")
 	}
-	msgName := proto.MessageName(protoMsg)
-
-	bufNamelen := make([]byte, 2)
-	bufName := []byte(msgName)
-	namelen := len(bufName)
-
-	if p.littleEndian {
-		binary.LittleEndian.PutUint16(bufNamelen, uint16(namelen))
-	} else {
-		binary.BigEndian.PutUint16(bufNamelen, uint16(namelen))
+	// the name of a raw message is not checked by proto
+	if _, ok := msg.(proto.Message); !ok {
+		if _, ok := p.msgInfo[msgName]; !ok {
+			return nil, fmt.Errorf("message %v not registered", msgName)
+		}
+	}
+	id, err := p.writeID(msgName)
+	if err != nil {
+		return nil, err
 	}
-	// data
-	data, err := proto.Marshal(msg.(proto.Message))
+
 	//encrypt
-	endata := xxtea.EncryptExt(data, keybuf)
-	// fmt.Printf("Marshal endata len:%v\n", len(endata))
-	// fmt.Printf("Marshal all len:%v\n", len(bufNamelen)+len(bufName)+len(endata))
-	return [][]byte{bufNamelen, bufName, endata}, err
+	cipher, err := p.getCipher()
+	if err != nil {
+		return nil, err
+	}
+	if cipher != nil {
+		data, err = cipher.Encrypt(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(id, data), nil
 }
-
-// goroutine safe
-// func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
-// 	for id, i := range p.msgInfo {
-// 		f(uint16(id), i.msgType)
-// 	}
-// }
@@ -0,0 +1,90 @@
+package protobuf_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/somethinghero/leaf/network/protobuf"
+)
+
+// a gateway passes a message through without decoding it
+func TestRawPassThrough(t *testing.T) {
+	for _, cipher := range []string{"none", "aes-gcm"} {
+		t.Run(cipher, func(t *testing.T) {
+			key := []byte("0123456789abcdef")
+
+			// the client and the server decode messages
+			typed := protobuf.NewProcessor()
+			typed.Register(&wrappers.StringValue{})
+			typed.SetCipherByName(cipher, key)
+
+			gateway := protobuf.NewProcessor()
+			name := gateway.Register(&wrappers.StringValue{})
+			gateway.SetCipherByName(cipher, key)
+			var routed []interface{}
+			gateway.SetRawHandler(name, func(args []interface{}) {
+				routed = args
+			})
+
+			data, err := typed.Marshal(&wrappers.StringValue{Value: "hello"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := gateway.Unmarshal(bytes.Join(data, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, ok := msg.(protobuf.MsgRaw)
+			if !ok || raw.Name != name {
+				t.Fatalf("%#v", msg)
+			}
+			// decrypted
+			var body wrappers.StringValue
+			if err := proto.Unmarshal(raw.Data, &body); err != nil || body.Value != "hello" {
+				t.Fatalf("%v, %v", body.Value, err)
+			}
+
+			if err := gateway.Route(msg, "agent"); err != nil {
+				t.Fatal(err)
+			}
+			if len(routed) != 3 || routed[0] != name || !bytes.Equal(routed[1].([]byte), raw.Data) || routed[2] != "agent" {
+				t.Fatalf("raw handler args: %v", routed)
+			}
+
+			// forwarded as MsgRaw and *MsgRaw
+			for _, forward := range []interface{}{raw, &raw} {
+				data, err = gateway.Marshal(forward)
+				if err != nil {
+					t.Fatal(err)
+				}
+				msg, err = typed.Unmarshal(bytes.Join(data, nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v := msg.(*wrappers.StringValue).Value; v != "hello" {
+					t.Fatalf("%q", v)
+				}
+			}
+		})
+	}
+}
+
+func TestRawNotRegistered(t *testing.T) {
+	modes := []protobuf.IDMode{protobuf.NameID, protobuf.Uint16ID, protobuf.Uint32ID}
+	for _, mode := range modes {
+		p := protobuf.NewProcessor()
+		p.SetIDMode(mode)
+		p.RegisterWithID(&wrappers.StringValue{}, 1)
+		if _, err := p.Marshal(protobuf.MsgRaw{Name: "google.protobuf.Int32Value"}); err == nil {
+			t.Fatalf("mode %v: marshaled an unregistered raw message", mode)
+		}
+		if _, err := p.Marshal(&protobuf.MsgRaw{Name: ""}); err == nil {
+			t.Fatalf("mode %v: marshaled a raw message without name", mode)
+		}
+		if _, err := p.Marshal(protobuf.MsgRaw{Name: "google.protobuf.StringValue"}); err != nil {
+			t.Fatalf("mode %v: %v", mode, err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package network_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/somethinghero/leaf/network"
+)
+
+// writes n messages and reads until the conn is closed
+type burstAgent struct {
+	conn    network.Conn
+	n       int
+	size    int
+	written chan struct{}
+	closed  chan struct{}
+}
+
+func newBurstAgent(n, size int) *burstAgent {
+	return &burstAgent{
+		n:       n,
+		size:    size,
+		written: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (a *burstAgent) Run() {
+	msg := bytes.Repeat([]byte{'x'}, a.size)
+	for i := 0; i < a.n; i++ {
+		a.conn.WriteMsg(msg)
+	}
+	close(a.written)
+	for {
+		if _, err := a.conn.ReadMsg(); err != nil {
+			return
+		}
+	}
+}
+
+func (a *burstAgent) OnClose() {
+	close(a.closed)
+}
+
+type shutdownServer interface {
+	Shutdown(timeout time.Duration)
+}
+
+// Shutdown in the background, the time it takes is sent to the channel
+func shutdown(server shutdownServer, timeout time.Duration) chan time.Duration {
+	c := make(chan time.Duration, 1)
+	go func() {
+		begin := time.Now()
+		server.Shutdown(timeout)
+		c <- time.Since(begin)
+	}()
+	return c
+}
+
+func waitChan(t *testing.T, c chan struct{}, what string) {
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%v timeout", what)
+	}
+}
+
+func waitShutdown(t *testing.T, c chan time.Duration) time.Duration {
+	select {
+	case d := <-c:
+		return d
+	case <-time.After(10 * time.Second):
+		t.Fatal("Shutdown does not return")
+	}
+	return 0
+}
+
+func startBurstTCPServer(t *testing.T, agent *burstAgent, pendingWriteNum int) *network.TCPServer {
+	server := &network.TCPServer{
+		Addr:            freeAddr(t),
+		MaxConnNum:      10,
+		PendingWriteNum: pendingWriteNum,
+		LenMsgLen:       2,
+		MaxMsgLen:       65535,
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			agent.conn = conn
+			return agent
+		},
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func startBurstWSServer(t *testing.T, agent *burstAgent, pendingWriteNum int) *network.WSServer {
+	server := &network.WSServer{
+		Addr:            freeAddr(t),
+		MaxConnNum:      10,
+		PendingWriteNum: pendingWriteNum,
+		LenMsgLen:       2,
+		MaxMsgLen:       65535,
+		Framing:         network.WSFramingRaw,
+		NewAgent: func(conn *network.WSConn) network.Agent {
+			agent.conn = conn
+			return agent
+		},
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// the pending writes are flushed before the conns are closed
+func TestShutdownDrain(t *testing.T) {
+	const n, size = 100, 100
+
+	t.Run("tcp", func(t *testing.T) {
+		agent := newBurstAgent(n, size)
+		server := startBurstTCPServer(t, agent, n+1)
+		conn, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		waitChan(t, agent.written, "write")
+		c := shutdown(server, 5*time.Second)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		received := 0
+		header := make([]byte, 2)
+		for {
+			if _, err := io.ReadFull(conn, header); err != nil {
+				break
+			}
+			if _, err := io.ReadFull(conn, make([]byte, binary.BigEndian.Uint16(header))); err != nil {
+				break
+			}
+			received++
+		}
+		if received != n {
+			t.Fatalf("received %v, want %v", received, n)
+		}
+		if d := waitShutdown(t, c); d > 2*time.Second {
+			t.Fatalf("Shutdown took %v", d)
+		}
+		waitChan(t, agent.closed, "close")
+	})
+
+	t.Run("ws", func(t *testing.T) {
+		agent := newBurstAgent(n, size)
+		server := startBurstWSServer(t, agent, n+1)
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		waitChan(t, agent.written, "write")
+		c := shutdown(server, 5*time.Second)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		received := 0
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+			received++
+		}
+		if received != n {
+			t.Fatalf("received %v, want %v", received, n)
+		}
+		if d := waitShutdown(t, c); d > 2*time.Second {
+			t.Fatalf("Shutdown took %v", d)
+		}
+		waitChan(t, agent.closed, "close")
+	})
+
+	t.Run("kcp", func(t *testing.T) {
+		agent := newBurstAgent(n, size)
+		server := &network.KCPServer{
+			Addr:            freeUDPAddr(t),
+			MaxConnNum:      10,
+			PendingWriteNum: n + 1,
+			LenMsgLen:       2,
+			MaxMsgLen:       65535,
+			NewAgent: func(conn *network.KCPConn) network.Agent {
+				agent.conn = conn
+				return agent
+			},
+		}
+		if err := server.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		// kcp has no connection, the client says hello first
+		received := make(chan int, 1)
+		client := &network.KCPClient{
+			Addr:            server.Addr,
+			ConnectInterval: 10 * time.Millisecond,
+			LenMsgLen:       2,
+			MaxMsgLen:       65535,
+			NewAgent: func(conn *network.KCPConn) network.Agent {
+				return &countAgent{conn: conn, n: n, received: received}
+			},
+		}
+		if err := client.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		waitChan(t, agent.written, "write")
+		c := shutdown(server, 5*time.Second)
+
+		select {
+		case r := <-received:
+			if r != n {
+				t.Fatalf("received %v, want %v", r, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("receive timeout")
+		}
+		if d := waitShutdown(t, c); d > 2*time.Second {
+			t.Fatalf("Shutdown took %v", d)
+		}
+		waitChan(t, agent.closed, "close")
+	})
+}
+
+// the conns which can not be flushed in time are closed
+func TestShutdownTimeout(t *testing.T) {
+	// more than the socket buffers, the client does not read
+	const n, size = 600, 60000
+	const timeout = 300 * time.Millisecond
+
+	t.Run("tcp", func(t *testing.T) {
+		agent := newBurstAgent(n, size)
+		server := startBurstTCPServer(t, agent, n+1)
+		conn, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		waitChan(t, agent.written, "write")
+
+		if d := waitShutdown(t, shutdown(server, timeout)); d < timeout {
+			t.Fatalf("Shutdown took %v", d)
+		}
+		waitChan(t, agent.closed, "close")
+	})
+
+	t.Run("ws", func(t *testing.T) {
+		agent := newBurstAgent(n, size)
+		server := startBurstWSServer(t, agent, n+1)
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		waitChan(t, agent.written, "write")
+
+		if d := waitShutdown(t, shutdown(server, timeout)); d < timeout {
+			t.Fatalf("Shutdown took %v", d)
+		}
+		waitChan(t, agent.closed, "close")
+	})
+}
+
+// says hello and sends the number of messages received when it is n or the
+// conn is closed
+type countAgent struct {
+	conn     network.Conn
+	n        int
+	received chan int
+}
+
+func (a *countAgent) Run() {
+	a.conn.WriteMsg([]byte("hello"))
+	received := 0
+	for received < a.n {
+		if _, err := a.conn.ReadMsg(); err != nil {
+			break
+		}
+		received++
+	}
+	a.received <- received
+}
+
+func (a *countAgent) OnClose() {}
@@ -1,6 +1,8 @@
 package network
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
 	"sync"
 	"time"
@@ -20,6 +22,12 @@ type TCPClient struct {
 	conns           ConnSet
 	wg              sync.WaitGroup
 	closeFlag       bool
+	tlsConfig       *tls.Config
+
+	// nil means no tls
+	TLS *TLSConfig
+	// timeout of connecting, including the tls handshake, default 10s
+	HandshakeTimeout time.Duration
 
 	// msg parser
 	LenMsgLen    int
@@ -30,19 +38,30 @@ type TCPClient struct {
 }
 
 //Start start
-func (client *TCPClient) Start() {
-	client.init()
+func (client *TCPClient) Start() error {
+	err := client.init()
+	if err != nil {
+		return err
+	}
 
 	for i := 0; i < client.ConnNum; i++ {
 		client.wg.Add(1)
 		go client.connect()
 	}
+	return nil
 }
 
-func (client *TCPClient) init() {
+func (client *TCPClient) init() error {
 	client.Lock()
 	defer client.Unlock()
 
+	if client.NewAgent == nil {
+		return errors.New("NewAgent must not be nil")
+	}
+	if client.conns != nil {
+		return errors.New("client is running")
+	}
+
 	if client.ConnNum <= 0 {
 		client.ConnNum = 1
 		log.Release("invalid ConnNum, reset to %v", client.ConnNum)
@@ -55,11 +74,17 @@ func (client *TCPClient) init() {
 		client.PendingWriteNum = 100
 		log.Release("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
 	}
-	if client.NewAgent == nil {
-		log.Fatal("NewAgent must not be nil")
+	if client.HandshakeTimeout <= 0 {
+		client.HandshakeTimeout = 10 * time.Second
+		log.Release("invalid HandshakeTimeout, reset to %v", client.HandshakeTimeout)
 	}
-	if client.conns != nil {
-		log.Fatal("client is running")
+
+	if client.TLS != nil {
+		tlsConfig, err := client.TLS.clientConfig(client.Addr)
+		if err != nil {
+			return err
+		}
+		client.tlsConfig = tlsConfig
 	}
 
 	client.conns = make(ConnSet)
@@ -70,14 +95,34 @@ func (client *TCPClient) init() {
 	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
 	msgParser.SetByteOrder(client.LittleEndian)
 	client.msgParser = msgParser
+	return nil
+}
+
+func (client *TCPClient) doDial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: client.HandshakeTimeout}
+	if client.tlsConfig == nil {
+		return dialer.Dial("tcp", client.Addr)
+	}
+	// a nil *tls.Conn must not be returned as a non-nil net.Conn
+	conn, err := tls.DialWithDialer(dialer, "tcp", client.Addr, client.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
 }
 
 func (client *TCPClient) dial() net.Conn {
 	for {
-		conn, err := net.Dial("tcp", client.Addr)
-		if err == nil || client.closeFlag {
+		conn, err := client.doDial()
+		if err == nil {
 			return conn
 		}
+		client.Lock()
+		closeFlag := client.closeFlag
+		client.Unlock()
+		if closeFlag {
+			return nil
+		}
 
 		log.Release("connect to %v error: %v", client.Addr, err)
 		time.Sleep(client.ConnectInterval)
@@ -1,9 +1,10 @@
 package network
 
 import (
-	"errors"
+	"crypto/tls"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/somethinghero/leaf/log"
 )
@@ -18,6 +19,10 @@ type TCPConn struct {
 	writeChan chan []byte
 	closeFlag bool
 	msgParser *MsgParser
+	// 0 means no timeout
+	idleTimeout time.Duration
+	// why the connection is closed by the network layer
+	closeErr error
 }
 
 func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
@@ -48,7 +53,14 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPCo
 }
 
 func (tcpConn *TCPConn) doDestroy() {
-	tcpConn.conn.(*net.TCPConn).SetLinger(0)
+	switch conn := tcpConn.conn.(type) {
+	case *net.TCPConn:
+		conn.SetLinger(0)
+	case *tls.Conn:
+		if tc, ok := conn.NetConn().(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+	}
 	tcpConn.conn.Close()
 
 	if !tcpConn.closeFlag {
@@ -77,14 +89,18 @@ func (tcpConn *TCPConn) Close() {
 	tcpConn.closeFlag = true
 }
 
-func (tcpConn *TCPConn) doWrite(b []byte) {
+func (tcpConn *TCPConn) doWrite(b []byte) error {
 	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
 		log.Debug("close conn: channel full")
+		if tcpConn.closeErr == nil {
+			tcpConn.closeErr = ErrWriteChannelFull
+		}
 		tcpConn.doDestroy()
-		return
+		return ErrWriteChannelFull
 	}
 
 	tcpConn.writeChan <- b
+	return nil
 }
 
 //Write b must not be modified by the others goroutines
@@ -92,13 +108,34 @@ func (tcpConn *TCPConn) Write(b []byte) (int, error) {
 	tcpConn.Lock()
 	defer tcpConn.Unlock()
 	if tcpConn.closeFlag || b == nil {
-		return 0, errors.New("conn closed")
+		return 0, ErrConnClosed
 	}
 
-	tcpConn.doWrite(b)
+	err := tcpConn.doWrite(b)
+	if err != nil {
+		return 0, err
+	}
 	return len(b), nil
 }
 
+// record err as the reason why the connection is closed
+func (tcpConn *TCPConn) setCloseErr(err error) {
+	tcpConn.Lock()
+	defer tcpConn.Unlock()
+	if tcpConn.closeErr == nil {
+		tcpConn.closeErr = err
+	}
+}
+
+//CloseErr desc:
+// why the connection is closed by the network layer, e.g.
+// ErrWriteChannelFull or ErrShutdown, nil if it is not
+func (tcpConn *TCPConn) CloseErr() error {
+	tcpConn.Lock()
+	defer tcpConn.Unlock()
+	return tcpConn.closeErr
+}
+
 //Read read
 func (tcpConn *TCPConn) Read(b []byte) (int, error) {
 	return tcpConn.conn.Read(b)
@@ -116,6 +153,9 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 
 //ReadMsg read msg
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
+	if tcpConn.idleTimeout > 0 {
+		tcpConn.conn.SetReadDeadline(time.Now().Add(tcpConn.idleTimeout))
+	}
 	return tcpConn.msgParser.Read(tcpConn)
 }
 
@@ -1,6 +1,8 @@
 package network
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
 	"sync"
 	"time"
@@ -15,11 +17,17 @@ type TCPServer struct {
 	PendingWriteNum int
 	NewAgent        func(*TCPConn) Agent
 	ln              net.Listener
-	conns           ConnSet
+	conns           map[net.Conn]*TCPConn
 	mutexConns      sync.Mutex
 	wgLn            sync.WaitGroup
 	wgConns         sync.WaitGroup
 
+	// nil means no tls
+	TLS *TLSConfig
+
+	// close connections without messages read for IdleTimeout, 0 means no timeout
+	IdleTimeout time.Duration
+
 	// msg parser
 	LenMsgLen    int
 	MinMsgLen    uint32
@@ -29,15 +37,33 @@ type TCPServer struct {
 }
 
 //Start start tcp server
-func (server *TCPServer) Start() {
-	server.init()
+func (server *TCPServer) Start() error {
+	err := server.init()
+	if err != nil {
+		return err
+	}
+
+	server.wgLn.Add(1)
 	go server.run()
+	return nil
 }
 
-func (server *TCPServer) init() {
+func (server *TCPServer) init() error {
+	if server.NewAgent == nil {
+		return errors.New("NewAgent must not be nil")
+	}
+
 	ln, err := net.Listen("tcp", server.Addr)
 	if err != nil {
-		log.Fatal("%v", err)
+		return err
+	}
+	if server.TLS != nil {
+		config, err := server.TLS.serverConfig()
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, config)
 	}
 
 	if server.MaxConnNum <= 0 {
@@ -48,22 +74,19 @@ func (server *TCPServer) init() {
 		server.PendingWriteNum = 100
 		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
 	}
-	if server.NewAgent == nil {
-		log.Fatal("NewAgent must not be nil")
-	}
 
 	server.ln = ln
-	server.conns = make(ConnSet)
+	server.conns = make(map[net.Conn]*TCPConn)
 
 	// msg parser
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
 	msgParser.SetByteOrder(server.LittleEndian)
 	server.msgParser = msgParser
+	return nil
 }
 
 func (server *TCPServer) run() {
-	server.wgLn.Add(1)
 	defer server.wgLn.Done()
 
 	var tempDelay time.Duration
@@ -94,12 +117,13 @@ func (server *TCPServer) run() {
 			log.Debug("too many connections")
 			continue
 		}
-		server.conns[conn] = struct{}{}
+		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
+		tcpConn.idleTimeout = server.IdleTimeout
+		server.conns[conn] = tcpConn
 		server.mutexConns.Unlock()
 
 		server.wgConns.Add(1)
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
 		agent := server.NewAgent(tcpConn)
 		go func() {
 			agent.Run()
@@ -116,13 +140,34 @@ func (server *TCPServer) run() {
 	}
 }
 
+//Shutdown desc:
+// stop accepting connections and close every connection after its pending
+// writes are flushed, the connections still open after timeout are closed
+func (server *TCPServer) Shutdown(timeout time.Duration) {
+	server.ln.Close()
+	server.wgLn.Wait()
+
+	server.mutexConns.Lock()
+	for _, tcpConn := range server.conns {
+		tcpConn.setCloseErr(ErrShutdown)
+		tcpConn.Close()
+	}
+	server.mutexConns.Unlock()
+
+	if !waitTimeout(&server.wgConns, timeout) {
+		log.Release("tcp server %v: close connections after %v", server.Addr, timeout)
+	}
+	server.Close()
+}
+
 //Close close
 func (server *TCPServer) Close() {
 	server.ln.Close()
 	server.wgLn.Wait()
 
 	server.mutexConns.Lock()
-	for conn := range server.conns {
+	for conn, tcpConn := range server.conns {
+		tcpConn.setCloseErr(ErrShutdown)
 		conn.Close()
 	}
 	server.conns = nil
@@ -0,0 +1,112 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+//TLSConfig desc:
+// tls of a tcp server or client
+// server: CertFile and KeyFile are required, client certificates are
+// required and verified with CAFile if it is set (mTLS)
+// client: the server is verified with CAFile, or the system roots if it is
+// not set, CertFile and KeyFile are the client certificate of mTLS
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// PEM encoded CA certificates
+	CAFile string
+	// client: the name of the server to verify, default to the host of Addr
+	ServerName string
+	// client: do not verify the server, for testing only
+	InsecureSkipVerify bool
+	// "1.0", "1.1", "1.2" (default) or "1.3"
+	MinVersion string
+}
+
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown tls version: %v", version)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in %v", caFile)
+	}
+	return pool, nil
+}
+
+func (config *TLSConfig) common() (*tls.Config, error) {
+	minVersion, err := tlsVersion(config.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (config *TLSConfig) serverConfig() (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("tls CertFile and KeyFile required")
+	}
+	tlsConfig, err := config.common()
+	if err != nil {
+		return nil, err
+	}
+	if config.CAFile != "" {
+		tlsConfig.ClientCAs, err = loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+func (config *TLSConfig) clientConfig(addr string) (*tls.Config, error) {
+	tlsConfig, err := config.common()
+	if err != nil {
+		return nil, err
+	}
+	if config.CAFile != "" {
+		tlsConfig.RootCAs, err = loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig.ServerName = config.ServerName
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = host
+	}
+	tlsConfig.InsecureSkipVerify = config.InsecureSkipVerify
+	return tlsConfig, nil
+}
@@ -0,0 +1,232 @@
+package network_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/somethinghero/leaf/network"
+)
+
+type testCerts struct {
+	caFile         string
+	serverCertFile string
+	serverKeyFile  string
+	clientCertFile string
+	clientKeyFile  string
+}
+
+// a ca and the server and client certificates signed by it
+func newTestCerts(t *testing.T) *testCerts {
+	dir := t.TempDir()
+	writePEM := func(name, typ string, der []byte) string {
+		file := filepath.Join(dir, name)
+		data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "leaf ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writePEM(name+".crt", "CERTIFICATE", der), writePEM(name+".key", "EC PRIVATE KEY", keyDER)
+	}
+
+	certs := &testCerts{caFile: writePEM("ca.crt", "CERTIFICATE", caDER)}
+	certs.serverCertFile, certs.serverKeyFile = issue("server", 2, x509.ExtKeyUsageServerAuth)
+	certs.clientCertFile, certs.clientKeyFile = issue("client", 3, x509.ExtKeyUsageClientAuth)
+	return certs
+}
+
+// writes hello and sends the result of reading the echo
+type helloAgent struct {
+	conn   network.Conn
+	result chan error
+}
+
+func (a *helloAgent) Run() {
+	err := a.conn.WriteMsg([]byte("hello"))
+	if err == nil {
+		var data []byte
+		data, err = a.conn.ReadMsg()
+		if err == nil && string(data) != "hello" {
+			err = fmt.Errorf("echo %q", data)
+		}
+	}
+	a.result <- err
+}
+
+func (a *helloAgent) OnClose() {}
+
+func startTLSServer(t *testing.T, tlsConfig *network.TLSConfig) *network.TCPServer {
+	server := &network.TCPServer{
+		Addr:            freeAddr(t),
+		MaxConnNum:      10,
+		PendingWriteNum: 10,
+		LenMsgLen:       2,
+		MaxMsgLen:       4096,
+		TLS:             tlsConfig,
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			return &echoAgent{conn: conn}
+		},
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTLS(t *testing.T) {
+	certs := newTestCerts(t)
+
+	tests := []struct {
+		name   string
+		server *network.TLSConfig
+		client *network.TLSConfig
+		ok     bool
+	}{
+		{
+			"tls",
+			&network.TLSConfig{CertFile: certs.serverCertFile, KeyFile: certs.serverKeyFile},
+			&network.TLSConfig{CAFile: certs.caFile},
+			true,
+		},
+		{
+			"mtls",
+			&network.TLSConfig{CertFile: certs.serverCertFile, KeyFile: certs.serverKeyFile, CAFile: certs.caFile},
+			&network.TLSConfig{CAFile: certs.caFile, CertFile: certs.clientCertFile, KeyFile: certs.clientKeyFile},
+			true,
+		},
+		{
+			"mtls without a client certificate",
+			&network.TLSConfig{CertFile: certs.serverCertFile, KeyFile: certs.serverKeyFile, CAFile: certs.caFile},
+			&network.TLSConfig{CAFile: certs.caFile},
+			false,
+		},
+		{
+			"unknown server",
+			&network.TLSConfig{CertFile: certs.serverCertFile, KeyFile: certs.serverKeyFile},
+			&network.TLSConfig{},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startTLSServer(t, test.server)
+
+			result := make(chan error, 1)
+			client := &network.TCPClient{
+				Addr:            server.Addr,
+				ConnectInterval: 10 * time.Millisecond,
+				PendingWriteNum: 10,
+				LenMsgLen:       2,
+				MaxMsgLen:       4096,
+				TLS:             test.client,
+				NewAgent: func(conn *network.TCPConn) network.Agent {
+					return &helloAgent{conn: conn, result: result}
+				},
+			}
+			if err := client.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			select {
+			case err := <-result:
+				if (err == nil) != test.ok {
+					t.Fatalf("hello: %v", err)
+				}
+			case <-time.After(time.Second):
+				// the handshake fails in dial, the client keeps retrying
+				if test.ok {
+					t.Fatal("no connection")
+				}
+			}
+		})
+	}
+}
+
+// Close while the client fails to connect
+func TestTLSClientCloseOnDialError(t *testing.T) {
+	certs := newTestCerts(t)
+	client := &network.TCPClient{
+		Addr:            freeAddr(t),
+		ConnNum:         4,
+		ConnectInterval: 10 * time.Millisecond,
+		TLS:             &network.TLSConfig{CAFile: certs.caFile},
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			t.Error("connected")
+			return &echoAgent{conn: conn}
+		},
+	}
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		client.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close does not return")
+	}
+}
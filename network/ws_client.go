@@ -29,6 +29,9 @@ type WSClient struct {
 	MaxMsgLen    uint32
 	LittleEndian bool
 	msgParser    *MsgParser
+	// the same on both sides except WSFramingLegacy, the default, which writes
+	// messages with the len header of the msg parser but reads them without
+	Framing WSFraming
 }
 
 //Start start client
@@ -106,8 +109,6 @@ reconnect:
 	if conn == nil {
 		return
 	}
-	conn.SetReadLimit(int64(client.MaxMsgLen))
-
 	client.Lock()
 	if client.closeFlag {
 		client.Unlock()
@@ -117,7 +118,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, client.msgParser)
+	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, client.msgParser, client.Framing)
 	agent := client.NewAgent(wsConn)
 	agent.Run()
 
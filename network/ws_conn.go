@@ -1,9 +1,10 @@
 package network
 
 import (
-	"errors"
+	"crypto/tls"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/somethinghero/leaf/log"
@@ -12,6 +13,37 @@ import (
 //WebsocketConnSet manage all connections
 type WebsocketConnSet map[*websocket.Conn]struct{}
 
+//WSFraming how messages are put in websocket frames
+type WSFraming int
+
+// framings
+const (
+	// the framing of the earlier versions, kept as the default for the
+	// existing clients: a binary frame of | len | data | for every message
+	// written, a binary frame of data for every message read
+	WSFramingLegacy WSFraming = iota
+	// a binary frame of | len | data | for every message, see MsgParser
+	WSFramingLen
+	// a binary frame of data for every message
+	WSFramingRaw
+	// a text frame of data for every message, e.g. json messages
+	WSFramingText
+)
+
+func (framing WSFraming) String() string {
+	switch framing {
+	case WSFramingLegacy:
+		return "legacy"
+	case WSFramingLen:
+		return "len"
+	case WSFramingRaw:
+		return "raw"
+	case WSFramingText:
+		return "text"
+	}
+	return "unknown"
+}
+
 //WSConn wb socket connection
 type WSConn struct {
 	sync.Mutex
@@ -20,14 +52,31 @@ type WSConn struct {
 	maxMsgLen uint32
 	closeFlag bool
 	msgParser *MsgParser
+	framing   WSFraming
+	// 0 means no timeout
+	idleTimeout time.Duration
+	// why the connection is closed by the network layer
+	closeErr error
 }
 
-func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, msgParser *MsgParser) *WSConn {
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, msgParser *MsgParser, framing WSFraming) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
 	wsConn.writeChan = make(chan []byte, pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
 	wsConn.msgParser = msgParser
+	wsConn.framing = framing
+
+	readLimit := int64(maxMsgLen)
+	if framing == WSFramingLen {
+		readLimit += int64(msgParser.lenMsgLen)
+	}
+	conn.SetReadLimit(readLimit)
+
+	messageType := websocket.BinaryMessage
+	if framing == WSFramingText {
+		messageType = websocket.TextMessage
+	}
 
 	go func() {
 		for b := range wsConn.writeChan {
@@ -35,7 +84,7 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, msgP
 				break
 			}
 			// log.Debug("WS WriteMessage len:%v", len(b))
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			err := conn.WriteMessage(messageType, b)
 			if err != nil {
 				break
 			}
@@ -50,8 +99,33 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, msgP
 	return wsConn
 }
 
+// websocket pings also keep the connection alive
+func (wsConn *WSConn) setIdleTimeout(idleTimeout time.Duration) {
+	wsConn.idleTimeout = idleTimeout
+	if idleTimeout <= 0 {
+		return
+	}
+
+	conn := wsConn.conn
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent || IsTimeout(err) {
+			return nil
+		}
+		return err
+	})
+}
+
 func (wsConn *WSConn) doDestroy() {
-	wsConn.conn.UnderlyingConn().(*net.TCPConn).SetLinger(0)
+	switch conn := wsConn.conn.UnderlyingConn().(type) {
+	case *net.TCPConn:
+		conn.SetLinger(0)
+	case *tls.Conn:
+		if tc, ok := conn.NetConn().(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+	}
 	wsConn.conn.Close()
 
 	if !wsConn.closeFlag {
@@ -80,14 +154,18 @@ func (wsConn *WSConn) Close() {
 	wsConn.closeFlag = true
 }
 
-func (wsConn *WSConn) doWrite(b []byte) {
+func (wsConn *WSConn) doWrite(b []byte) error {
 	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
 		log.Debug("close conn: channel full")
+		if wsConn.closeErr == nil {
+			wsConn.closeErr = ErrWriteChannelFull
+		}
 		wsConn.doDestroy()
-		return
+		return ErrWriteChannelFull
 	}
 
 	wsConn.writeChan <- b
+	return nil
 }
 
 //Write b must not be modified by the others goroutines
@@ -95,13 +173,34 @@ func (wsConn *WSConn) Write(b []byte) (int, error) {
 	wsConn.Lock()
 	defer wsConn.Unlock()
 	if wsConn.closeFlag || b == nil {
-		return 0, errors.New("conn closed")
+		return 0, ErrConnClosed
 	}
 
-	wsConn.doWrite(b)
+	err := wsConn.doWrite(b)
+	if err != nil {
+		return 0, err
+	}
 	return len(b), nil
 }
 
+// record err as the reason why the connection is closed
+func (wsConn *WSConn) setCloseErr(err error) {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	if wsConn.closeErr == nil {
+		wsConn.closeErr = err
+	}
+}
+
+//CloseErr desc:
+// why the connection is closed by the network layer, e.g.
+// ErrWriteChannelFull or ErrShutdown, nil if it is not
+func (wsConn *WSConn) CloseErr() error {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	return wsConn.closeErr
+}
+
 //LocalAddr get local addr
 func (wsConn *WSConn) LocalAddr() net.Addr {
 	return wsConn.conn.LocalAddr()
@@ -114,11 +213,53 @@ func (wsConn *WSConn) RemoteAddr() net.Addr {
 
 //ReadMsg goroutine not safe
 func (wsConn *WSConn) ReadMsg() ([]byte, error) {
+	if wsConn.idleTimeout > 0 {
+		wsConn.conn.SetReadDeadline(time.Now().Add(wsConn.idleTimeout))
+	}
 	_, b, err := wsConn.conn.ReadMessage()
-	return b, err
+	if err == websocket.ErrReadLimit {
+		return nil, ErrMsgTooLong
+	}
+	if err != nil || wsConn.framing != WSFramingLen {
+		// legacy, raw or text
+		return b, err
+	}
+	return wsConn.msgParser.Unpack(b)
 }
 
 //WriteMsg args must not be modified by the others goroutines
 func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
-	return wsConn.msgParser.Write(wsConn, args...)
+	if wsConn.framing == WSFramingLen || wsConn.framing == WSFramingLegacy {
+		return wsConn.msgParser.Write(wsConn, args...)
+	}
+
+	// get len
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	// check len
+	if msgLen > wsConn.maxMsgLen {
+		return ErrMsgTooLong
+	} else if msgLen < 1 {
+		return ErrMsgTooShort
+	}
+
+	// don't copy
+	if len(args) == 1 {
+		_, err := wsConn.Write(args[0])
+		return err
+	}
+
+	// merge the args
+	msg := make([]byte, msgLen)
+	l := 0
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	_, err := wsConn.Write(msg)
+	return err
 }
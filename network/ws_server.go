@@ -2,6 +2,7 @@ package network
 
 import (
 	"crypto/tls"
+	"errors"
 	"net"
 	"net/http"
 	"sync"
@@ -23,12 +24,19 @@ type WSServer struct {
 	ln              net.Listener
 	handler         *WSHandler
 
+	// close connections without messages or pings read for IdleTimeout,
+	// 0 means no timeout
+	IdleTimeout time.Duration
+
 	// msg parser
 	LenMsgLen    int
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
 	msgParser    *MsgParser
+	// the same on both sides except WSFramingLegacy, the default, which writes
+	// messages with the len header of the msg parser but reads them without
+	Framing WSFraming
 }
 
 //WSHandler web socket handler
@@ -37,11 +45,13 @@ type WSHandler struct {
 	pendingWriteNum int
 	maxMsgLen       uint32
 	newAgent        func(*WSConn) Agent
+	idleTimeout     time.Duration
 	upgrader        websocket.Upgrader
-	conns           WebsocketConnSet
+	conns           map[*websocket.Conn]*WSConn
 	mutexConns      sync.Mutex
 	wg              sync.WaitGroup
 	msgParser       *MsgParser
+	framing         WSFraming
 }
 
 //ServeHTTP web socket http
@@ -55,8 +65,6 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Debug("upgrade error: %v", err)
 		return
 	}
-	conn.SetReadLimit(int64(handler.maxMsgLen))
-
 	handler.wg.Add(1)
 	defer handler.wg.Done()
 
@@ -72,10 +80,11 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Debug("too many connections")
 		return
 	}
-	handler.conns[conn] = struct{}{}
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, handler.msgParser, handler.framing)
+	wsConn.setIdleTimeout(handler.idleTimeout)
+	handler.conns[conn] = wsConn
 	handler.mutexConns.Unlock()
 
-	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, handler.msgParser)
 	agent := handler.newAgent(wsConn)
 	agent.Run()
 
@@ -87,31 +96,27 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	agent.OnClose()
 }
 
-func (server *WSServer) init() {
-	if server.MaxConnNum <= 0 {
-		server.MaxConnNum = 100
-		log.Release("invalid MaxConnNum, reset to %v", server.MaxConnNum)
-	}
-	if server.PendingWriteNum <= 0 {
-		server.PendingWriteNum = 100
-		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
-	}
+func (server *WSServer) init() error {
 	if server.NewAgent == nil {
-		log.Fatal("NewAgent must not be nil")
+		return errors.New("NewAgent must not be nil")
 	}
-	// msg parser
-	msgParser := NewMsgParser()
-	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
-	msgParser.SetByteOrder(server.LittleEndian)
-	server.msgParser = msgParser
-}
 
-//Start start web socket
-func (server *WSServer) Start() {
-	server.init()
 	ln, err := net.Listen("tcp", server.Addr)
 	if err != nil {
-		log.Fatal("%v", err)
+		return err
+	}
+	if server.CertFile != "" || server.KeyFile != "" {
+		config := &tls.Config{}
+		config.NextProtos = []string{"http/1.1"}
+
+		config.Certificates = make([]tls.Certificate, 1)
+		config.Certificates[0], err = tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+
+		ln = tls.NewListener(ln, config)
 	}
 
 	if server.MaxConnNum <= 0 {
@@ -130,37 +135,37 @@ func (server *WSServer) Start() {
 		server.HTTPTimeout = 10 * time.Second
 		log.Release("invalid HTTPTimeout, reset to %v", server.HTTPTimeout)
 	}
-	if server.NewAgent == nil {
-		log.Fatal("NewAgent must not be nil")
-	}
 
-	if server.CertFile != "" || server.KeyFile != "" {
-		config := &tls.Config{}
-		config.NextProtos = []string{"http/1.1"}
-
-		var err error
-		config.Certificates = make([]tls.Certificate, 1)
-		config.Certificates[0], err = tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
-		if err != nil {
-			log.Fatal("%v", err)
-		}
-
-		ln = tls.NewListener(ln, config)
-	}
+	// msg parser
+	msgParser := NewMsgParser()
+	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
+	msgParser.SetByteOrder(server.LittleEndian)
+	server.msgParser = msgParser
 
 	server.ln = ln
 	server.handler = &WSHandler{
 		maxConnNum:      server.MaxConnNum,
 		pendingWriteNum: server.PendingWriteNum,
 		maxMsgLen:       server.MaxMsgLen,
+		idleTimeout:     server.IdleTimeout,
 		newAgent:        server.NewAgent,
-		conns:           make(WebsocketConnSet),
+		conns:           make(map[*websocket.Conn]*WSConn),
 		msgParser:       server.msgParser,
+		framing:         server.Framing,
 		upgrader: websocket.Upgrader{
 			HandshakeTimeout: server.HTTPTimeout,
 			CheckOrigin:      func(_ *http.Request) bool { return true },
 		},
 	}
+	return nil
+}
+
+//Start start web socket
+func (server *WSServer) Start() error {
+	err := server.init()
+	if err != nil {
+		return err
+	}
 
 	httpServer := &http.Server{
 		Addr:           server.Addr,
@@ -170,7 +175,27 @@ func (server *WSServer) Start() {
 		MaxHeaderBytes: 1024,
 	}
 
-	go httpServer.Serve(ln)
+	go httpServer.Serve(server.ln)
+	return nil
+}
+
+//Shutdown desc:
+// stop accepting connections and close every connection after its pending
+// writes are flushed, the connections still open after timeout are closed
+func (server *WSServer) Shutdown(timeout time.Duration) {
+	server.ln.Close()
+
+	server.handler.mutexConns.Lock()
+	for _, wsConn := range server.handler.conns {
+		wsConn.setCloseErr(ErrShutdown)
+		wsConn.Close()
+	}
+	server.handler.mutexConns.Unlock()
+
+	if !waitTimeout(&server.handler.wg, timeout) {
+		log.Release("ws server %v: close connections after %v", server.Addr, timeout)
+	}
+	server.Close()
 }
 
 //Close close web socket
@@ -178,7 +203,8 @@ func (server *WSServer) Close() {
 	server.ln.Close()
 
 	server.handler.mutexConns.Lock()
-	for conn := range server.handler.conns {
+	for conn, wsConn := range server.handler.conns {
+		wsConn.setCloseErr(ErrShutdown)
 		conn.Close()
 	}
 	server.handler.conns = nil
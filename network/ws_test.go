@@ -0,0 +1,88 @@
+package network_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/somethinghero/leaf/network"
+)
+
+type echoAgent struct {
+	conn network.Conn
+}
+
+func (a *echoAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(data)
+	}
+}
+
+func (a *echoAgent) OnClose() {}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestWSFraming(t *testing.T) {
+	tests := []struct {
+		framing network.WSFraming
+		// the frame written by the client and the frame of the echo
+		write, read []byte
+		messageType int
+	}{
+		// the wire format of the earlier versions
+		{network.WSFramingLegacy, []byte("hello"), []byte("\x00\x05hello"), websocket.BinaryMessage},
+		{network.WSFramingLen, []byte("\x00\x05hello"), []byte("\x00\x05hello"), websocket.BinaryMessage},
+		{network.WSFramingRaw, []byte("hello"), []byte("hello"), websocket.BinaryMessage},
+		{network.WSFramingText, []byte("hello"), []byte("hello"), websocket.TextMessage},
+	}
+
+	for _, test := range tests {
+		t.Run(test.framing.String(), func(t *testing.T) {
+			server := &network.WSServer{
+				Addr:            freeAddr(t),
+				MaxConnNum:      10,
+				PendingWriteNum: 10,
+				MaxMsgLen:       4096,
+				LenMsgLen:       2,
+				Framing:         test.framing,
+				NewAgent: func(conn *network.WSConn) network.Agent {
+					return &echoAgent{conn: conn}
+				},
+			}
+			if err := server.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if err := conn.WriteMessage(websocket.BinaryMessage, test.write); err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messageType != test.messageType || !bytes.Equal(data, test.read) {
+				t.Fatalf("%v %q, want %v %q", messageType, data, test.messageType, test.read)
+			}
+		})
+	}
+}